@@ -1,22 +1,114 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"OurChat/internal/api"
+	"OurChat/internal/config"
 )
 
+// Time allowed to read request headers, so idle clients can't hold
+// connections open before sending a request
+const readHeaderTimeout = 10 * time.Second
+
 func main() {
+	// Read the configuration file named by the flag or the environment
+	configPath := flag.String("config", os.Getenv(config.ConfigFileEnv), "path to a YAML config file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: ourchat [-config file] [migrate <command> | user <command> <username>]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Manage the database schema or accounts without starting the server
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "migrate":
+			os.Exit(runMigrate(cfg, flag.Args()[1:]))
+		case "user":
+			os.Exit(runUser(cfg, flag.Args()[1:]))
+		default:
+			flag.Usage()
+			os.Exit(2)
+		}
+	}
+
+	log.Printf("Effective configuration:\n%s", cfg)
+
 	// Create a new API server
-	server := api.NewServer()
+	server := api.NewServer(cfg)
 
 	// Configure the server routes
 	server.SetupRoutes()
 
+	httpServer := &http.Server{
+		Addr:              cfg.Server.Address(),
+		Handler:           server.Handler(),
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+		MaxHeaderBytes:    int(cfg.Server.MaxHeaderBytes),
+	}
+
+	// Realtime connections never finish on their own, so end them when
+	// shutdown starts; hijacked WebSockets aren't tracked by the server
+	httpServer.RegisterOnShutdown(server.Hub.Close)
+
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Start the server
-	log.Println("Starting OurChat server on :8080")
-	if err := http.ListenAndServe(":8080", server.Router); err != nil {
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Starting OurChat server on %s", httpServer.Addr)
+		serverErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatalf("Server failed: %v", err)
+	case <-ctx.Done():
+	}
+
+	// A second signal kills the server right away
+	stop()
+
+	log.Printf("Shutting down, waiting up to %s for requests to finish", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	exitCode := 0
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Requests didn't finish in time: %v", err)
+		httpServer.Close()
+		exitCode = 1
+	}
+	if err := <-serverErr; !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Server failed: %v", err)
+		exitCode = 1
 	}
+
+	if err := server.Close(); err != nil {
+		log.Printf("Failed to shut down cleanly: %v", err)
+		exitCode = 1
+	}
+
+	log.Println("Server stopped")
+	os.Exit(exitCode)
 }
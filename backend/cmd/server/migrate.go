@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"OurChat/internal/config"
+	"OurChat/internal/db"
+)
+
+const migrateUsage = `usage: ourchat [-config file] migrate <command>
+
+commands:
+  status     list migrations and whether they are applied
+  up [n]     apply n pending migrations, or all of them
+  down [n]   roll back the last n applied migrations (default 1). Rolling
+             back the initial migration drops every table`
+
+// runMigrate runs the migrate subcommand and returns the exit code
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	steps := 0
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid number of migrations %q\n", args[1])
+			return 2
+		}
+		steps = n
+	}
+
+	database, err := db.Open(cfg.Database.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	switch args[0] {
+	case "status":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		return printMigrationStatus(database)
+
+	case "up":
+		applied, err := database.MigrateUp(steps)
+		fmt.Printf("Applied %d migration(s)\n", applied)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	case "down":
+		if steps == 0 {
+			steps = 1
+		}
+		rolledBack, err := database.MigrateDown(steps)
+		fmt.Printf("Rolled back %d migration(s)\n", rolledBack)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
+
+// printMigrationStatus prints a table of the migrations and when they were
+// applied
+func printMigrationStatus(database *db.DB) int {
+	statuses, err := database.MigrationStatus()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, status := range statuses {
+		appliedAt := "pending"
+		if status.AppliedAt != nil {
+			appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+		} else if status.Legacy {
+			appliedAt = "legacy, unversioned"
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+	}
+	w.Flush()
+
+	return 0
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"OurChat/internal/config"
+	"OurChat/internal/db"
+)
+
+const userUsage = `usage: ourchat [-config file] user <command> <username>
+
+commands:
+  disable    disable an account and log it out everywhere
+  enable     enable a disabled account again
+  reset-2fa  turn off two-factor authentication for a user who lost their
+             authenticator and recovery codes`
+
+// runUser runs the user subcommand and returns the exit code
+func runUser(cfg *config.Config, args []string) int {
+	if len(args) != 2 || (args[0] != "disable" && args[0] != "enable" && args[0] != "reset-2fa") {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	database, err := db.NewDB(cfg.Database.Path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+
+	user, err := database.GetUserByUsername(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "User %s not found\n", args[1])
+		return 1
+	}
+
+	if args[0] == "reset-2fa" {
+		if user.TOTPEnabledAt == nil {
+			fmt.Fprintf(os.Stderr, "User %s doesn't have two-factor authentication enabled\n", user.Username)
+			return 1
+		}
+
+		if err := database.DisableTOTP(user.ID); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Printf("Turned off two-factor authentication for user %s (%d)\n", user.Username, user.ID)
+		return 0
+	}
+
+	disable := args[0] == "disable"
+	if err := database.SetUserDisabled(user.ID, disable); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if disable {
+		fmt.Printf("Disabled user %s (%d)\n", user.Username, user.ID)
+	} else {
+		fmt.Printf("Enabled user %s (%d)\n", user.Username, user.ID)
+	}
+
+	return 0
+}
@@ -1,56 +1,167 @@
 package api
 
 import (
+	"fmt"
+	"io/fs"
 	"log"
+	"net/http"
+	"os"
+	"strings"
 
 	"OurChat/internal/api/handlers"
 	"OurChat/internal/api/middleware"
+	"OurChat/internal/config"
 	"OurChat/internal/db"
+	"OurChat/internal/mail"
+	"OurChat/internal/presence"
+	"OurChat/internal/realtime"
+	"OurChat/internal/revocation"
+	"OurChat/internal/web"
 
 	"github.com/gorilla/mux"
 )
 
 type Server struct {
-	Router         *mux.Router
-	DB             *db.DB
-	AuthHandler    *handlers.AuthHandler
-	UserHandler    *handlers.UserHandler
-	ChatHandler    *handlers.ChatHandler
-	MessageHandler *handlers.MessageHandler
-	MediaHandler   *handlers.MediaHandler
-	AuthMiddleware *middleware.AuthMiddleware
+	Config             *config.Config
+	Router             *mux.Router
+	DB                 *db.DB
+	AuthHandler        *handlers.AuthHandler
+	UserHandler        *handlers.UserHandler
+	ChatHandler        *handlers.ChatHandler
+	MessageHandler     *handlers.MessageHandler
+	MediaHandler       *handlers.MediaHandler
+	RealtimeHandler    *handlers.RealtimeHandler
+	PresenceHandler    *handlers.PresenceHandler
+	AuthMiddleware     *middleware.AuthMiddleware
+	PresenceMiddleware *middleware.PresenceMiddleware
+	CORSMiddleware     *middleware.CORSMiddleware
+	Hub                *realtime.Hub
+	Presence           *presence.Service
+	Revocations        *revocation.Store
+	Watcher            *revocation.Watcher
+	Mail               *mail.Queue
+	// Frontend build served outside /api, nil to serve only the API
+	Frontend fs.FS
+	// Restricts actions of users who haven't verified their email
+	VerificationMiddleware *middleware.VerificationMiddleware
 }
 
 // NewServer creates a new API server
-func NewServer() *Server {
+func NewServer(cfg *config.Config) *Server {
 	// Create a new router
 	router := mux.NewRouter()
 
 	// Connect to database
-	database, err := db.NewDB("./data/ourchat.db")
+	database, err := db.NewDB(cfg.Database.Path)
 	if err != nil {
 		log.Fatalf("Error connecting to database: %v", err)
 	}
 
+	// Load the tokens revoked before they expire
+	revocations, err := revocation.NewStore(database, cfg.Auth.TokenLifetime)
+	if err != nil {
+		log.Fatalf("Error loading revoked tokens: %v", err)
+	}
+
+	// Deliver emails in the background
+	mailer, err := newMailer(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Error setting up mail: %v", err)
+	}
+	mailQueue := mail.NewQueue(mailer)
+
+	// Create the realtime hub shared by all handlers that publish events
+	hub := realtime.NewHub()
+	presenceService := presence.NewService(database, hub, presence.Options{
+		TypingTTL:    cfg.Presence.TypingTTL,
+		AwayAfter:    cfg.Presence.AwayAfter,
+		OfflineAfter: cfg.Presence.OfflineAfter,
+	})
+
+	// Close the realtime connections of users disabled from the command line
+	watcher := revocation.NewWatcher(database, hub)
+
 	// Create handlers
-	authHandler := handlers.NewAuthHandler(database)
-	userHandler := handlers.NewUserHandler(database)
-	chatHandler := handlers.NewChatHandler(database)
-	messageHandler := handlers.NewMessageHandler(database)
-	mediaHandler := handlers.NewMediaHandler(database)
+	verifier := handlers.NewEmailVerifier(database, mailQueue)
+	verifier.PublicURL = cfg.Server.PublicURL
+	verifier.Lifetime = cfg.Auth.EmailVerificationLifetime
+
+	authHandler := handlers.NewAuthHandler(database, hub, revocations, mailQueue, verifier)
+	authHandler.PublicURL = cfg.Server.PublicURL
+	authHandler.TokenLifetime = cfg.Auth.TokenLifetime
+	authHandler.PasswordResetLifetime = cfg.Auth.PasswordResetLifetime
+	authHandler.RefreshTokenLifetime = cfg.Auth.RefreshTokenLifetime
+	authHandler.TwoFactorChallengeLifetime = cfg.Auth.TwoFactorChallengeLifetime
+
+	userHandler := handlers.NewUserHandler(database, presenceService, verifier)
+	chatHandler := handlers.NewChatHandler(database, hub, presenceService)
+
+	messageHandler := handlers.NewMessageHandler(database, hub, cfg.Media.UploadDir)
+	messageHandler.EditWindow = cfg.Messages.EditWindow
+	messageHandler.MaxUploadSize = int64(cfg.Media.MaxUploadSize)
+
+	mediaHandler := handlers.NewMediaHandler(database, hub, cfg.Media.UploadDir)
+	mediaHandler.MaxUploadSize = int64(cfg.Media.MaxUploadSize)
+	mediaHandler.MaxProfilePictureSize = int64(cfg.Media.MaxProfilePictureSize)
+
+	realtimeHandler := handlers.NewRealtimeHandler(database, hub)
+	presenceHandler := handlers.NewPresenceHandler(database, presenceService)
 
 	// Create middleware
-	authMiddleware := middleware.NewAuthMiddleware(database)
+	authMiddleware := middleware.NewAuthMiddleware(database, revocations)
+	presenceMiddleware := middleware.NewPresenceMiddleware(presenceService)
+	corsMiddleware := middleware.NewCORSMiddleware(cfg.CORS.AllowedOrigins)
+	verificationMiddleware := middleware.NewVerificationMiddleware(database, cfg.Auth.UnverifiedRestrictions)
+
+	// Serve the frontend from disk if configured, else the embedded build
+	var frontend fs.FS
+	if cfg.Frontend.Dir != "" {
+		frontend = os.DirFS(cfg.Frontend.Dir)
+		log.Printf("Serving frontend from %s", cfg.Frontend.Dir)
+	} else if frontend = web.Embedded(); frontend != nil {
+		log.Println("Serving embedded frontend")
+	}
+	if frontend != nil && !web.HasFallback(frontend) {
+		log.Printf("Warning: frontend build has no %s, unknown paths will return 404", web.FallbackPage)
+	}
 
 	return &Server{
-		Router:         router,
-		DB:             database,
-		AuthHandler:    authHandler,
-		UserHandler:    userHandler,
-		ChatHandler:    chatHandler,
-		MessageHandler: messageHandler,
-		MediaHandler:   mediaHandler,
-		AuthMiddleware: authMiddleware,
+		Config:             cfg,
+		Router:             router,
+		DB:                 database,
+		AuthHandler:        authHandler,
+		UserHandler:        userHandler,
+		ChatHandler:        chatHandler,
+		MessageHandler:     messageHandler,
+		MediaHandler:       mediaHandler,
+		RealtimeHandler:    realtimeHandler,
+		PresenceHandler:    presenceHandler,
+		AuthMiddleware:     authMiddleware,
+		PresenceMiddleware: presenceMiddleware,
+		CORSMiddleware:     corsMiddleware,
+		Hub:                hub,
+		Presence:           presenceService,
+		Revocations:        revocations,
+		Watcher:            watcher,
+		Mail:               mailQueue,
+		Frontend:           frontend,
+
+		VerificationMiddleware: verificationMiddleware,
+	}
+}
+
+// newMailer creates the mailer selected by the configuration
+func newMailer(cfg config.MailConfig) (mail.Mailer, error) {
+	switch cfg.Driver {
+	case config.MailDriverSMTP:
+		log.Printf("Sending email through %s:%d", cfg.SMTP.Host, cfg.SMTP.Port)
+		return mail.NewSMTPMailer(cfg.From, cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password), nil
+	case config.MailDriverFile:
+		log.Printf("Writing emails to %s instead of sending them", cfg.Dir)
+		return mail.NewFileMailer(cfg.From, cfg.Dir)
+	default:
+		log.Println("Writing emails to the log instead of sending them")
+		return mail.NewLogMailer(cfg.From), nil
 	}
 }
 
@@ -62,64 +173,109 @@ func (s *Server) SetupRoutes() {
 	// Auth routes - no authentication required
 	api.HandleFunc("/register", s.AuthHandler.HandleRegister).Methods("POST")
 	api.HandleFunc("/login", s.AuthHandler.HandleLogin).Methods("POST")
+	api.HandleFunc("/login/2fa", s.AuthHandler.HandleLoginTwoFactor).Methods("POST")
+	api.HandleFunc("/refresh", s.AuthHandler.HandleRefresh).Methods("POST")
 	api.HandleFunc("/request-password-reset", s.AuthHandler.HandleRequestPasswordReset).Methods("POST")
 	api.HandleFunc("/reset-password", s.AuthHandler.HandleResetPassword).Methods("POST")
+	api.HandleFunc("/verify-email", s.AuthHandler.HandleVerifyEmail).Methods("POST")
 
 	// Protected routes - authentication required
 	protected := api.PathPrefix("").Subrouter()
 	protected.Use(s.AuthMiddleware.Middleware)
+	protected.Use(s.PresenceMiddleware.Middleware)
+
+	// Wraps the handlers of actions that may need a verified email
+	verified := s.VerificationMiddleware.Require
 
 	// User routes
 	protected.HandleFunc("/logout", s.AuthHandler.HandleLogout).Methods("POST")
+	protected.HandleFunc("/logout-all", s.AuthHandler.HandleLogoutAll).Methods("POST")
+	protected.HandleFunc("/resend-verification", s.AuthHandler.HandleResendVerification).Methods("POST")
+	protected.HandleFunc("/sessions", s.AuthHandler.HandleGetSessions).Methods("GET")
+	protected.HandleFunc("/sessions/{sessionID:[0-9]+}", s.AuthHandler.HandleRevokeSession).Methods("DELETE")
+	protected.HandleFunc("/2fa", s.AuthHandler.HandleGetTwoFactor).Methods("GET")
+	protected.HandleFunc("/2fa/setup", s.AuthHandler.HandleSetupTwoFactor).Methods("POST")
+	protected.HandleFunc("/2fa/confirm", s.AuthHandler.HandleConfirmTwoFactor).Methods("POST")
+	protected.HandleFunc("/2fa/disable", s.AuthHandler.HandleDisableTwoFactor).Methods("POST")
+	protected.HandleFunc("/2fa/recovery-codes", s.AuthHandler.HandleRegenerateRecoveryCodes).Methods("POST")
 	protected.HandleFunc("/profile", s.UserHandler.HandleGetProfile).Methods("GET")
 	protected.HandleFunc("/profile", s.UserHandler.HandleUpdateProfile).Methods("PUT")
-	protected.HandleFunc("/profile/picture", s.MediaHandler.HandleUploadProfilePicture).Methods("POST")
+	protected.HandleFunc("/profile/picture", verified(config.ActionUploadMedia, s.MediaHandler.HandleUploadProfilePicture)).Methods("POST")
 
 	// Media routes
-	protected.HandleFunc("/media/upload", s.MediaHandler.HandleUploadMedia).Methods("POST")
+	protected.HandleFunc("/media/upload", verified(config.ActionUploadMedia, s.MediaHandler.HandleUploadMedia)).Methods("POST")
 	protected.HandleFunc("/media/{type}/{filename}", s.MediaHandler.HandleServeMedia).Methods("GET")
 
 	// Chat routes
 	protected.HandleFunc("/chats", s.ChatHandler.HandleGetChats).Methods("GET")
-	protected.HandleFunc("/chats", s.ChatHandler.HandleCreateChat).Methods("POST")
+	protected.HandleFunc("/chats", verified(config.ActionCreateChat, s.ChatHandler.HandleCreateChat)).Methods("POST")
 	protected.HandleFunc("/chats/{chatID}", s.ChatHandler.HandleGetChat).Methods("GET")
+	protected.HandleFunc("/chats/{chatID}", s.ChatHandler.HandleUpdateChat).Methods("PUT")
+	protected.HandleFunc("/chats/{chatID}/avatar", verified(config.ActionUploadMedia, s.MediaHandler.HandleUploadChatAvatar)).Methods("POST")
 	protected.HandleFunc("/chats/{chatID}/members", s.ChatHandler.HandleGetChatMembers).Methods("GET")
+	protected.HandleFunc("/chats/{chatID}/members", s.ChatHandler.HandleAddMembers).Methods("POST")
+	protected.HandleFunc("/chats/{chatID}/members/{userID:[0-9]+}", s.ChatHandler.HandleRemoveMember).Methods("DELETE")
+	protected.HandleFunc("/chats/{chatID}/members/{userID:[0-9]+}/role", s.ChatHandler.HandleUpdateMemberRole).Methods("PUT")
+	protected.HandleFunc("/chats/{chatID}/leave", s.ChatHandler.HandleLeaveChat).Methods("POST")
+	protected.HandleFunc("/chats/{chatID}/invites", s.ChatHandler.HandleGetInvites).Methods("GET")
+	protected.HandleFunc("/chats/{chatID}/invites", s.ChatHandler.HandleCreateInvite).Methods("POST")
+	protected.HandleFunc("/chats/{chatID}/invites/{inviteID:[0-9]+}", s.ChatHandler.HandleRevokeInvite).Methods("DELETE")
+	protected.HandleFunc("/invites/{token}/join", verified(config.ActionJoinChat, s.ChatHandler.HandleJoinWithInvite)).Methods("POST")
 
 	// Message routes
+	protected.HandleFunc("/messages/search", s.MessageHandler.HandleSearchAllMessages).Methods("GET")
 	protected.HandleFunc("/chats/{chatID}/messages", s.MessageHandler.HandleGetMessages).Methods("GET")
-	protected.HandleFunc("/chats/{chatID}/messages", s.MessageHandler.HandleSendMessage).Methods("POST")
+	protected.HandleFunc("/chats/{chatID}/messages", verified(config.ActionSendMessage, s.MessageHandler.HandleSendMessage)).Methods("POST")
 	protected.HandleFunc("/chats/{chatID}/messages/read", s.MessageHandler.HandleMarkMessagesAsRead).Methods("POST")
 	protected.HandleFunc("/chats/{chatID}/messages/search", s.MessageHandler.HandleSearchMessages).Methods("GET")
-	protected.HandleFunc("/chats/{chatID}/messages/media", s.MessageHandler.HandleSendMediaMessage).Methods("POST")
+	protected.HandleFunc("/chats/{chatID}/messages/media", verified(config.ActionSendMessage, verified(config.ActionUploadMedia, s.MessageHandler.HandleSendMediaMessage))).Methods("POST")
+	protected.HandleFunc("/chats/{chatID}/messages/{messageID:[0-9]+}", s.MessageHandler.HandleEditMessage).Methods("PUT")
+	protected.HandleFunc("/chats/{chatID}/messages/{messageID:[0-9]+}", s.MessageHandler.HandleDeleteMessage).Methods("DELETE")
+	protected.HandleFunc("/chats/{chatID}/messages/{messageID:[0-9]+}/edits", s.MessageHandler.HandleGetMessageEdits).Methods("GET")
+	protected.HandleFunc("/chats/{chatID}/messages/{messageID:[0-9]+}/thread", s.MessageHandler.HandleGetThread).Methods("GET")
+	protected.HandleFunc("/chats/{chatID}/messages/{messageID:[0-9]+}/reads", s.MessageHandler.HandleGetMessageReads).Methods("GET")
+	protected.HandleFunc("/chats/{chatID}/messages/{messageID:[0-9]+}/reactions", s.MessageHandler.HandleAddReaction).Methods("POST")
+	protected.HandleFunc("/chats/{chatID}/messages/{messageID:[0-9]+}/reactions/{emoji}", s.MessageHandler.HandleRemoveReaction).Methods("DELETE")
+
+	// Presence routes
+	protected.HandleFunc("/chats/{chatID}/typing", s.PresenceHandler.HandleGetTyping).Methods("GET")
+	protected.HandleFunc("/chats/{chatID}/typing", s.PresenceHandler.HandleSetTyping).Methods("POST")
+
+	// Realtime routes
+	protected.HandleFunc("/ws", s.RealtimeHandler.HandleWebSocket).Methods("GET")
+	protected.HandleFunc("/events", s.RealtimeHandler.HandleEvents).Methods("GET")
 
 	// Helper routes
 	protected.HandleFunc("/users/search", s.UserHandler.HandleSearchUsers).Methods("GET")
 	protected.HandleFunc("/users", s.UserHandler.HandleGetUsersByIDs).Methods("GET", "POST")
 
-	// s.Router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-	// 	// Construct the file path
-	// 	filesRoot := "static-frontend"
-
-	// 	if r.URL.Path == "/index" {
-	// 		http.Redirect(w, r, "/", http.StatusFound)
-	// 		return
-	// 	}
+	// Frontend routes - everything outside the API, if a build is available
+	if s.Frontend != nil {
+		s.Router.PathPrefix("/").
+			MatcherFunc(func(r *http.Request, _ *mux.RouteMatch) bool {
+				return !strings.HasPrefix(r.URL.Path, "/api/")
+			}).
+			Methods("GET", "HEAD").
+			Handler(web.NewHandler(s.Frontend))
+	}
+}
 
-	// 	// Check if the file exists
-	// 	if _, err := http.Dir(filesRoot).Open(r.URL.Path); err != nil {
-	// 		// If file not found, check for {file}.html
-	// 		if _, err := http.Dir(filesRoot).Open(r.URL.Path + ".html"); err != nil {
-	// 			// If file not found, serve 200.html
-	// 			http.ServeFile(w, r, filesRoot+"/200.html")
-	// 			return
-	// 		}
+// Handler returns the HTTP handler serving every route
+func (s *Server) Handler() http.Handler {
+	return s.CORSMiddleware.Middleware(s.Router)
+}
 
-	// 		http.ServeFile(w, r, filesRoot+r.URL.Path+".html")
-	// 		return
-	// 	}
+// Close stops the background workers and closes the database. Realtime
+// connections must already be closed, see Hub.Close.
+func (s *Server) Close() error {
+	s.Presence.Close()
+	s.Watcher.Close()
+	s.Revocations.Close()
+	s.Mail.Close()
 
-	// 	// Serve the requested file
-	// 	http.FileServer(http.Dir(filesRoot)).ServeHTTP(w, r)
-	// })
+	if err := s.DB.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
 
+	return nil
 }
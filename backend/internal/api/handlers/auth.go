@@ -2,41 +2,94 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"OurChat/internal/api/utils"
 	"OurChat/internal/db"
+	"OurChat/internal/mail"
+	"OurChat/internal/models"
+	"OurChat/internal/ratelimit"
+	"OurChat/internal/realtime"
+	"OurChat/internal/revocation"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthHandler contains handlers related to authentication
 type AuthHandler struct {
-	DB *db.DB
+	DB          *db.DB
+	Hub         *realtime.Hub
+	Revocations *revocation.Store
+	Mailer      mail.Mailer
+	Verifier    *EmailVerifier
+	// Address of the web app, for links in emails
+	PublicURL string
+	// How long access tokens and password reset tokens stay valid
+	TokenLifetime         time.Duration
+	PasswordResetLifetime time.Duration
+	// How long a session survives without refreshing its tokens
+	RefreshTokenLifetime time.Duration
+	// How long users with 2FA have to enter a code after their password
+	TwoFactorChallengeLifetime time.Duration
+
+	resetLimiter     *ratelimit.Limiter
+	twoFactorLimiter *ratelimit.Limiter
 }
 
+// Password reset emails allowed per address every passwordResetWindow
+const (
+	passwordResetLimit  = 3
+	passwordResetWindow = time.Hour
+)
+
 // NewAuthHandler creates a new authentication handler
-func NewAuthHandler(db *db.DB) *AuthHandler {
+func NewAuthHandler(db *db.DB, hub *realtime.Hub, revocations *revocation.Store, mailer mail.Mailer, verifier *EmailVerifier) *AuthHandler {
 	return &AuthHandler{
-		DB: db,
+		DB:                    db,
+		Hub:                   hub,
+		Revocations:           revocations,
+		Mailer:                mailer,
+		Verifier:              verifier,
+		PublicURL:             "http://localhost:8080",
+		resetLimiter:          ratelimit.NewLimiter(passwordResetLimit, passwordResetWindow),
+		twoFactorLimiter:      ratelimit.NewLimiter(twoFactorAttemptLimit, twoFactorAttemptWindow),
+		TokenLifetime:         utils.JWTExpiration,
+		PasswordResetLifetime: utils.PasswordResetExpiration,
+		RefreshTokenLifetime:  utils.RefreshTokenExpiration,
+
+		TwoFactorChallengeLifetime: utils.TwoFactorChallengeExpiration,
 	}
 }
 
 // LoginRequest represents the login request body
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	DeviceName string `json:"device_name"` // Optional, shown in the session list
 }
 
-// LoginResponse represents the login response body
+// LoginResponse represents the login response body, also returned when
+// refreshing tokens
 type LoginResponse struct {
-	Token   string `json:"token"`
-	UserID  int    `json:"user_id"`
-	Message string `json:"message"`
+	Token          string    `json:"token"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+	RefreshToken   string    `json:"refresh_token"`
+	SessionID      int       `json:"session_id"`
+	UserID         int       `json:"user_id"`
+	Message        string    `json:"message"`
 }
 
-// HandleLogin handles user login and token generation
+// Maximum length of a device name
+const maxDeviceNameLength = 100
+
+// HandleLogin handles user login and token generation. Users with 2FA get a
+// challenge token instead, see HandleLoginTwoFactor.
 func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var req LoginRequest
@@ -60,10 +113,37 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(user)
+	if user.DisabledAt != nil {
+		log.Printf("Login attempt for disabled user %s", req.Username)
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+
+	if len(req.DeviceName) > maxDeviceNameLength {
+		http.Error(w, "Device name is too long", http.StatusBadRequest)
+		return
+	}
+
+	// With 2FA on, the password alone doesn't start a session
+	if user.TOTPEnabledAt != nil {
+		h.writeTwoFactorChallenge(w, user, req.DeviceName)
+		return
+	}
+
+	h.startSession(w, r, user, req.DeviceName)
+}
+
+// startSession logs a user in on a device after they proved who they are
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user *models.User, deviceName string) {
+	// Clean up the user's old sessions before adding one
+	if err := h.DB.DeleteStaleSessions(user.ID); err != nil {
+		log.Printf("Failed to delete stale sessions: %v", err)
+	}
+
+	// Start a session for this device
+	session, refreshToken, err := h.DB.CreateSession(user.ID, deviceName, clientIP(r), r.UserAgent(), h.RefreshTokenLifetime)
 	if err != nil {
-		log.Printf("Failed to generate token: %v", err)
+		log.Printf("Failed to create session: %v", err)
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
@@ -74,11 +154,27 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Failed to update last login: %v", err)
 	}
 
-	// Return token in response
+	h.writeTokens(w, user, session, refreshToken, "Login successful")
+}
+
+// writeTokens issues an access token for the session and responds with it and
+// the session's refresh token
+func (h *AuthHandler) writeTokens(w http.ResponseWriter, user *models.User, session *models.Session, refreshToken, message string) {
+	expiresAt := time.Now().Add(h.TokenLifetime)
+	token, err := utils.GenerateJWT(user, session.ID, h.TokenLifetime)
+	if err != nil {
+		log.Printf("Failed to generate token: %v", err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
 	response := LoginResponse{
-		Token:   token,
-		UserID:  user.ID,
-		Message: "Login successful",
+		Token:          token,
+		TokenExpiresAt: expiresAt.UTC().Truncate(time.Second),
+		RefreshToken:   refreshToken,
+		SessionID:      session.ID,
+		UserID:         user.ID,
+		Message:        message,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -95,20 +191,28 @@ func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update user status to offline
-	if err := h.DB.UpdateUserStatus(userID, "offline"); err != nil {
-		log.Printf("Failed to update user status: %v", err)
-		// Continue despite error - not critical
+	// Revoke the token used for this request
+	if tokenID, ok := r.Context().Value("token_id").(string); ok {
+		expiresAt, _ := r.Context().Value("token_expires_at").(time.Time)
+		if err := h.Revocations.RevokeToken(tokenID, userID, expiresAt); err != nil {
+			log.Printf("Failed to revoke token: %v", err)
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	// Optionally, update JWT key to invalidate all tokens
-	// This would force the user to login again on all devices
-	// Uncomment if you want this behavior
-	/*
-		if _, err := h.DB.UpdateJWTKey(userID); err != nil {
-			log.Printf("Failed to update JWT key: %v", err)
+	// End this device's session so its other tokens stop working. Other
+	// devices stay logged in, see HandleLogoutAll.
+	if sessionID, ok := r.Context().Value("session_id").(int); ok {
+		if err := h.Revocations.RevokeSession(sessionID, userID); err != nil {
+			log.Printf("Failed to revoke session %d: %v", sessionID, err)
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
 		}
-	*/
+
+		// Close this device's realtime connections
+		h.Hub.DisconnectSession(sessionID)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -151,6 +255,14 @@ func (h *AuthHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check that the email looks deliverable, it is verified below
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	req.Email = email
+
 	// Check if username already exists
 	_, err := h.DB.GetUserByUsername(req.Username)
 	if err == nil {
@@ -188,10 +300,16 @@ func (h *AuthHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The account works right away, but some actions may need a verified
+	// email, so a failure to send is only logged. The user can ask again.
+	if err := h.Verifier.Send(user, user.Email); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	// Return success response
 	response := RegisterResponse{
 		UserID:  user.ID,
-		Message: "User registered successfully",
+		Message: "User registered successfully, check your email to verify your address",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -204,7 +322,8 @@ type RequestPasswordResetRequest struct {
 	Email string `json:"email"`
 }
 
-// HandleRequestPasswordReset initiates the password reset process
+// HandleRequestPasswordReset emails a password reset link to the address, if
+// it belongs to an account
 func (h *AuthHandler) HandleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
 	// Parse request body
 	var req RequestPasswordResetRequest
@@ -213,42 +332,86 @@ func (h *AuthHandler) HandleRequestPasswordReset(w http.ResponseWriter, r *http.
 		return
 	}
 
-	// Validate input
-	if req.Email == "" {
+	// Validate input. Addresses are matched case-insensitively, so the rate
+	// limit and the lookup both use the lowercased form.
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" {
 		http.Error(w, "Email is required", http.StatusBadRequest)
 		return
 	}
 
-	// Find user by email
-	user, err := h.DB.RequestPasswordReset(req.Email)
+	// Limit the emails sent to one address, whether it exists or not
+	if ok, retryAfter := h.resetLimiter.Allow(email); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Too many password reset requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	// The response is the same whether the email exists or not, and the
+	// email is sent in the background. This prevents email enumeration.
+	h.sendPasswordReset(email)
 
-	// Always return success, even if email doesn't exist
-	// This prevents email enumeration attacks
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If your email is registered, you will receive a password reset link shortly",
+	})
+}
+
+// sendPasswordReset emails a reset link to the user with the given email.
+// Failures are only logged, since they must not show in the response.
+func (h *AuthHandler) sendPasswordReset(email string) {
+	// Find user by email
+	user, err := h.DB.RequestPasswordReset(email)
 	if err != nil {
-		log.Printf("Password reset request for non-existent email %s: %v", req.Email, err)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"message": "If your email is registered, you will receive a password reset link shortly",
-		})
+		log.Printf("Password reset request for non-existent email %s: %v", email, err)
+		return
+	}
+
+	if user.DisabledAt != nil {
+		log.Printf("Password reset request for disabled user %d", user.ID)
 		return
 	}
 
 	// Generate reset token
-	token, err := utils.GeneratePasswordResetToken(user)
+	token, err := utils.GeneratePasswordResetToken(user, h.PasswordResetLifetime)
 	if err != nil {
 		log.Printf("Failed to generate reset token: %v", err)
-		http.Error(w, "Failed to process request", http.StatusInternalServerError)
 		return
 	}
 
-	// Return success response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message": "If your email is registered, you will receive a password reset link shortly",
-		"token":   token,
-	})
+	link := strings.TrimSuffix(h.PublicURL, "/") + "/newPassword?token=" + url.QueryEscape(token)
+	msg := &mail.Message{
+		To:      user.Email,
+		Subject: "Reset your OurChat password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your OurChat account. Open this link to choose a new one:\n\n"+
+			"%s\n\n"+
+			"The link works once and expires in %s. If you didn't ask for this, you can ignore this email.\n",
+			user.Username, link, formatLifetime(h.PasswordResetLifetime)),
+	}
+
+	if err := h.Mailer.Send(msg); err != nil {
+		log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+	}
+}
+
+// formatLifetime writes a duration the way people say it, like "30 minutes"
+func formatLifetime(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		if d == time.Minute {
+			return "1 minute"
+		}
+		return fmt.Sprintf("%d minutes", d/time.Minute)
+	default:
+		return d.String()
+	}
 }
 
 // ResetPasswordRequest represents the password reset request with token
@@ -288,13 +451,17 @@ func (h *AuthHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Reset password and rotate JWT key
+	// Reset password and rotate JWT key. Reset tokens are signed with the
+	// key, so this also makes the token single-use.
 	if err := h.DB.ResetPassword(userID, string(hashedPassword)); err != nil {
 		log.Printf("Failed to reset password: %v", err)
 		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
 		return
 	}
 
+	// Streams opened before the reset outlive the old tokens
+	h.Hub.DisconnectUser(userID)
+
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
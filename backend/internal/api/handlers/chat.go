@@ -5,21 +5,29 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"OurChat/internal/db"
+	"OurChat/internal/models"
+	"OurChat/internal/presence"
+	"OurChat/internal/realtime"
 
 	"github.com/gorilla/mux"
 )
 
 // ChatHandler contains handlers related to chat management
 type ChatHandler struct {
-	DB *db.DB
+	DB       *db.DB
+	Hub      *realtime.Hub
+	Presence *presence.Service
 }
 
 // NewChatHandler creates a new chat handler
-func NewChatHandler(db *db.DB) *ChatHandler {
+func NewChatHandler(db *db.DB, hub *realtime.Hub, presence *presence.Service) *ChatHandler {
 	return &ChatHandler{
-		DB: db,
+		DB:       db,
+		Hub:      hub,
+		Presence: presence,
 	}
 }
 
@@ -32,13 +40,23 @@ func (h *ChatHandler) HandleGetChats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Archived chats are only listed on request
+	includeArchived := r.URL.Query().Get("include_archived") == "true"
+
 	// Get chats from database
-	chats, err := h.DB.GetChatsForUser(userID)
+	chats, err := h.DB.GetChatsForUser(userID, includeArchived)
 	if err != nil {
 		http.Error(w, "Failed to get chats", http.StatusInternalServerError)
 		return
 	}
 
+	// Show live presence for the other participant of direct chats
+	for i := range chats {
+		if chats[i].OtherUser != nil {
+			h.Presence.DecorateUser(chats[i].OtherUser)
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(chats)
 }
@@ -118,6 +136,8 @@ func (h *ChatHandler) HandleCreateChat(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		h.publishMembersChange(chat, "created", nil)
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(chat)
 		return
@@ -154,6 +174,8 @@ func (h *ChatHandler) HandleCreateChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.publishMembersChange(chat, "created", nil)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(chat)
@@ -235,6 +257,139 @@ func (h *ChatHandler) HandleGetChatMembers(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Replace stored statuses with live presence
+	for i := range members {
+		h.Presence.DecorateMember(&members[i])
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(members)
 }
+
+// Maximum length of a group chat description
+const maxChatDescriptionLength = 500
+
+// UpdateChatRequest represents a request to change a group chat's settings.
+// Fields left out are not changed.
+type UpdateChatRequest struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	IsActive    *bool   `json:"is_active,omitempty"`
+}
+
+// HandleUpdateChat changes the settings of a group chat. Only admins can
+// change them, including archiving the chat by setting is_active to false.
+func (h *ChatHandler) HandleUpdateChat(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, role, ok := h.getGroupChatForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if role != "admin" {
+		http.Error(w, "Only chat admins can change chat settings", http.StatusForbidden)
+		return
+	}
+
+	var req UpdateChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	updates := make(map[string]interface{})
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			http.Error(w, "Group chat name is required", http.StatusBadRequest)
+			return
+		}
+		updates["name"] = name
+	}
+
+	if req.Description != nil {
+		description := strings.TrimSpace(*req.Description)
+		if len([]rune(description)) > maxChatDescriptionLength {
+			http.Error(w, fmt.Sprintf("Description can be at most %d characters", maxChatDescriptionLength), http.StatusBadRequest)
+			return
+		}
+		updates["description"] = description
+	}
+
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+
+	if len(updates) == 0 {
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.UpdateChat(chat.ID, updates); err != nil {
+		http.Error(w, "Failed to update chat", http.StatusInternalServerError)
+		return
+	}
+
+	chat, err := h.DB.GetChatByID(chat.ID)
+	if err != nil {
+		http.Error(w, "Chat updated but failed to retrieve", http.StatusInternalServerError)
+		return
+	}
+
+	publishToChat(h.DB, h.Hub, chat.ID, newChatUpdatedEvent(chat))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chat)
+}
+
+// publishMembersChange notifies the members of a chat that its membership
+// changed. Users who were removed are notified as well.
+func (h *ChatHandler) publishMembersChange(chat *models.Chat, action string, userIDs []int) {
+	data := map[string]interface{}{
+		"action": action,
+		"chat":   chat,
+	}
+	if len(userIDs) > 0 {
+		data["user_ids"] = userIDs
+	}
+
+	event := &realtime.Event{
+		Type:   realtime.EventMembersChange,
+		ChatID: chat.ID,
+		Data:   data,
+	}
+
+	publishToChat(h.DB, h.Hub, chat.ID, event)
+	if action == "removed" || action == "left" {
+		h.Hub.SendToUsers(userIDs, event)
+	}
+}
+
+// checkChatActive rejects changes to archived chats. It writes the error
+// response and returns false if the chat is archived.
+func checkChatActive(w http.ResponseWriter, database *db.DB, chatID int) bool {
+	chat, err := database.GetChatByID(chatID)
+	if err != nil {
+		http.Error(w, "Failed to get chat", http.StatusInternalServerError)
+		return false
+	}
+
+	return requireActiveChat(w, chat)
+}
+
+// requireActiveChat is checkChatActive for a chat that is already loaded
+func requireActiveChat(w http.ResponseWriter, chat *models.Chat) bool {
+	if !chat.IsActive {
+		http.Error(w, "This chat is archived", http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"OurChat/internal/db"
+	"OurChat/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateInviteRequest represents a request to create an invite for a group chat
+type CreateInviteRequest struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   *int       `json:"max_uses,omitempty"`
+}
+
+// HandleCreateInvite creates an invite for a group chat. Only admins can create invites.
+func (h *ChatHandler) HandleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, role, ok := h.getGroupChatForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if role != "admin" {
+		http.Error(w, "Only chat admins can manage invites", http.StatusForbidden)
+		return
+	}
+
+	// The request body is optional
+	var req CreateInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "Expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	if req.MaxUses != nil && *req.MaxUses <= 0 {
+		http.Error(w, "Maximum uses must be positive", http.StatusBadRequest)
+		return
+	}
+
+	invite, err := h.DB.CreateChatInvite(chat.ID, userID, req.ExpiresAt, req.MaxUses)
+	if err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(invite)
+}
+
+// HandleGetInvites lists the invites of a group chat and who used them.
+// Only admins can see invites.
+func (h *ChatHandler) HandleGetInvites(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, role, ok := h.getGroupChatForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if role != "admin" {
+		http.Error(w, "Only chat admins can manage invites", http.StatusForbidden)
+		return
+	}
+
+	invites, err := h.DB.GetChatInvites(chat.ID)
+	if err != nil {
+		http.Error(w, "Failed to get invites", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// HandleRevokeInvite revokes an invite of a group chat. Only admins can revoke invites.
+func (h *ChatHandler) HandleRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, role, ok := h.getGroupChatForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if role != "admin" {
+		http.Error(w, "Only chat admins can manage invites", http.StatusForbidden)
+		return
+	}
+
+	inviteID, err := strconv.Atoi(mux.Vars(r)["inviteID"])
+	if err != nil {
+		http.Error(w, "Invalid invite ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RevokeChatInvite(inviteID, chat.ID); err != nil {
+		http.Error(w, "Invite not found or already revoked", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleJoinWithInvite adds the current user to the chat an invite belongs to
+func (h *ChatHandler) HandleJoinWithInvite(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	invite, err := h.DB.GetChatInviteByToken(mux.Vars(r)["token"])
+	if err != nil {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	chat, err := h.DB.GetChatByID(invite.ChatID)
+	if err != nil {
+		http.Error(w, "Failed to get chat", http.StatusInternalServerError)
+		return
+	}
+
+	// Members don't use up the invite
+	isMember, _, err := h.DB.IsUserChatMember(userID, chat.ID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if isMember {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(chat)
+		return
+	}
+
+	if reason := inviteUnusableReason(invite, time.Now()); reason != "" {
+		http.Error(w, reason, http.StatusGone)
+		return
+	}
+
+	if !chat.IsActive {
+		http.Error(w, "This chat is archived", http.StatusForbidden)
+		return
+	}
+
+	// Redeeming fails if the invite was used up by a concurrent join
+	if err := h.DB.RedeemChatInvite(invite.ID, userID); err != nil {
+		if errors.Is(err, db.ErrInviteNoLongerValid) {
+			http.Error(w, "Invite is no longer valid", http.StatusGone)
+			return
+		}
+		log.Printf("Failed to join chat %d with invite %d: %v", chat.ID, invite.ID, err)
+		http.Error(w, "Failed to add user to chat", http.StatusInternalServerError)
+		return
+	}
+
+	h.publishMembersChange(chat, "joined", []int{userID})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chat)
+}
+
+// inviteUnusableReason explains why an invite can't be used, or returns an
+// empty string if it can
+func inviteUnusableReason(invite *models.ChatInvite, now time.Time) string {
+	switch {
+	case invite.RevokedAt != nil:
+		return "Invite has been revoked"
+	case invite.ExpiresAt != nil && !invite.ExpiresAt.After(now):
+		return "Invite has expired"
+	case invite.MaxUses != nil && invite.UseCount >= *invite.MaxUses:
+		return "Invite has reached its maximum number of uses"
+	default:
+		return ""
+	}
+}
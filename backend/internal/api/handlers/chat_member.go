@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"OurChat/internal/db"
+	"OurChat/internal/models"
+
+	"github.com/gorilla/mux"
+)
+
+// AddMembersRequest represents a request to add users to a group chat
+type AddMembersRequest struct {
+	UserIDs []int `json:"user_ids"`
+}
+
+// UpdateRoleRequest represents a request to change a member's role
+type UpdateRoleRequest struct {
+	Role string `json:"role"` // "admin" or "member"
+}
+
+// HandleAddMembers adds users to a group chat. Only admins can add members.
+func (h *ChatHandler) HandleAddMembers(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, role, ok := h.getGroupChatForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if !requireActiveChat(w, chat) {
+		return
+	}
+
+	if role != "admin" {
+		http.Error(w, "Only chat admins can add members", http.StatusForbidden)
+		return
+	}
+
+	var req AddMembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.UserIDs) == 0 {
+		http.Error(w, "At least one user ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Validate every user before adding anyone, skipping existing members
+	var newUserIDs []int
+	seen := make(map[int]bool)
+	for _, newUserID := range req.UserIDs {
+		if seen[newUserID] {
+			continue
+		}
+		seen[newUserID] = true
+
+		if _, err := h.DB.GetUserByID(newUserID); err != nil {
+			http.Error(w, fmt.Sprintf("User with ID %d does not exist", newUserID), http.StatusBadRequest)
+			return
+		}
+
+		isMember, _, err := h.DB.IsUserChatMember(newUserID, chat.ID)
+		if err != nil {
+			http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+			return
+		}
+		if !isMember {
+			newUserIDs = append(newUserIDs, newUserID)
+		}
+	}
+
+	for _, newUserID := range newUserIDs {
+		if err := h.DB.AddUserToChat(newUserID, chat.ID, "member"); err != nil {
+			http.Error(w, "Failed to add user to chat", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if len(newUserIDs) > 0 {
+		h.publishMembersChange(chat, "added", newUserIDs)
+	}
+
+	h.writeChatMembers(w, chat.ID)
+}
+
+// HandleRemoveMember removes another member from a group chat. Only admins
+// can remove members; to remove yourself, leave the chat instead.
+func (h *ChatHandler) HandleRemoveMember(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, role, ok := h.getGroupChatForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if !requireActiveChat(w, chat) {
+		return
+	}
+
+	if role != "admin" {
+		http.Error(w, "Only chat admins can remove members", http.StatusForbidden)
+		return
+	}
+
+	memberID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if memberID == userID {
+		http.Error(w, "Use the leave endpoint to leave a chat", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.DB.RemoveUserFromChat(memberID, chat.ID); err != nil {
+		switch {
+		case errors.Is(err, db.ErrNotChatMember):
+			http.Error(w, "User is not a member of this chat", http.StatusNotFound)
+		case errors.Is(err, db.ErrLastChatAdmin):
+			http.Error(w, "A chat needs at least one admin", http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// A removed member can't keep typing in the chat
+	h.Presence.SetTyping(chat.ID, memberID, false)
+	h.publishMembersChange(chat, "removed", []int{memberID})
+
+	h.writeChatMembers(w, chat.ID)
+}
+
+// HandleUpdateMemberRole promotes a member to admin or demotes an admin.
+// Only admins can change roles, and a chat can't be left without an admin.
+func (h *ChatHandler) HandleUpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, role, ok := h.getGroupChatForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if !requireActiveChat(w, chat) {
+		return
+	}
+
+	if role != "admin" {
+		http.Error(w, "Only chat admins can change roles", http.StatusForbidden)
+		return
+	}
+
+	memberID, err := strconv.Atoi(mux.Vars(r)["userID"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Role != "admin" && req.Role != "member" {
+		http.Error(w, "Role must be admin or member", http.StatusBadRequest)
+		return
+	}
+
+	isMember, memberRole, err := h.DB.IsUserChatMember(memberID, chat.ID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "User is not a member of this chat", http.StatusNotFound)
+		return
+	}
+
+	if memberRole != req.Role {
+		// Demoting the last admin would leave nobody able to manage the chat
+		if err := h.DB.UpdateChatMemberRole(memberID, chat.ID, req.Role); err != nil {
+			switch {
+			case errors.Is(err, db.ErrNotChatMember):
+				http.Error(w, "User is not a member of this chat", http.StatusNotFound)
+			case errors.Is(err, db.ErrLastChatAdmin):
+				http.Error(w, "A chat needs at least one admin", http.StatusBadRequest)
+			default:
+				http.Error(w, "Failed to update member role", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		action := "promoted"
+		if req.Role == "member" {
+			action = "demoted"
+		}
+		h.publishMembersChange(chat, action, []int{memberID})
+	}
+
+	h.writeChatMembers(w, chat.ID)
+}
+
+// HandleLeaveChat removes the current user from a group chat. The last admin
+// has to promote someone else first, unless nobody else is left.
+func (h *ChatHandler) HandleLeaveChat(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chat, _, ok := h.getGroupChatForMember(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if err := h.DB.RemoveUserFromChat(userID, chat.ID); err != nil {
+		if errors.Is(err, db.ErrLastChatAdmin) {
+			http.Error(w, "Promote another member to admin before leaving", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to leave chat", http.StatusInternalServerError)
+		return
+	}
+
+	h.Presence.SetTyping(chat.ID, userID, false)
+	h.publishMembersChange(chat, "left", []int{userID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getGroupChatForMember loads the group chat from the URL and checks that the
+// user is one of its members. It writes the error response and returns false
+// if the chat can't be managed.
+func (h *ChatHandler) getGroupChatForMember(w http.ResponseWriter, r *http.Request, userID int) (*models.Chat, string, bool) {
+	// Get chat ID from URL
+	chatID, err := strconv.Atoi(mux.Vars(r)["chatID"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	// Check if user is a member of the chat
+	isMember, role, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return nil, "", false
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return nil, "", false
+	}
+
+	chat, err := h.DB.GetChatByID(chatID)
+	if err != nil {
+		http.Error(w, "Failed to get chat", http.StatusInternalServerError)
+		return nil, "", false
+	}
+
+	if chat.Type != "group" {
+		http.Error(w, "Members can only be managed in group chats", http.StatusBadRequest)
+		return nil, "", false
+	}
+
+	return chat, role, true
+}
+
+// writeChatMembers responds with the current members of a chat
+func (h *ChatHandler) writeChatMembers(w http.ResponseWriter, chatID int) {
+	members, err := h.DB.GetChatMembers(chatID)
+	if err != nil {
+		http.Error(w, "Failed to get chat members", http.StatusInternalServerError)
+		return
+	}
+
+	// Replace stored statuses with live presence
+	for i := range members {
+		h.Presence.DecorateMember(&members[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
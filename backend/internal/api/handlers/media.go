@@ -3,6 +3,7 @@ package handlers
 import (
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -10,11 +11,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"OurChat/internal/config"
 	"OurChat/internal/db"
 	"OurChat/internal/models"
+	"OurChat/internal/realtime"
 
 	"github.com/disintegration/imaging"
 
@@ -24,26 +28,33 @@ import (
 // MediaHandler handles media file uploads and serving
 type MediaHandler struct {
 	DB        *db.DB
+	Hub       *realtime.Hub
 	UploadDir string
+	// Largest accepted media file and profile picture or chat avatar, in bytes
+	MaxUploadSize         int64
+	MaxProfilePictureSize int64
 }
 
 const (
-	ProfilePictureSize    = 128             // 128x128 pixels
-	ProfilePictureQuality = 90              // JPEG quality
-	MaxProfilePictureSize = 5 * 1024 * 1024 // 5MB
+	ProfilePictureSize    = 128              // 128x128 pixels
+	ProfilePictureQuality = 90               // JPEG quality
+	MaxProfilePictureSize = 5 * 1024 * 1024  // 5MB, unless configured otherwise
+	MaxUploadSize         = 50 * 1024 * 1024 // 50MB, unless configured otherwise
 )
 
-// NewMediaHandler creates a new media handler
-func NewMediaHandler(db *db.DB) *MediaHandler {
-	uploadDir := "./uploads"
+// NewMediaHandler creates a new media handler storing files in uploadDir
+func NewMediaHandler(db *db.DB, hub *realtime.Hub, uploadDir string) *MediaHandler {
 	// Create upload directory if it doesn't exist
 	os.MkdirAll(uploadDir, 0755)
 	os.MkdirAll(filepath.Join(uploadDir, "profiles"), 0755)
 	os.MkdirAll(filepath.Join(uploadDir, "media"), 0755)
 
 	return &MediaHandler{
-		DB:        db,
-		UploadDir: uploadDir,
+		DB:                    db,
+		Hub:                   hub,
+		UploadDir:             uploadDir,
+		MaxUploadSize:         MaxUploadSize,
+		MaxProfilePictureSize: MaxProfilePictureSize,
 	}
 }
 
@@ -56,7 +67,7 @@ func (h *MediaHandler) HandleUploadProfilePicture(w http.ResponseWriter, r *http
 	}
 
 	// Parse multipart form
-	err := r.ParseMultipartForm(MaxProfilePictureSize)
+	err := r.ParseMultipartForm(h.MaxProfilePictureSize)
 	if err != nil {
 		http.Error(w, "Failed to parse form or file too large", http.StatusBadRequest)
 		return
@@ -76,8 +87,8 @@ func (h *MediaHandler) HandleUploadProfilePicture(w http.ResponseWriter, r *http
 	}
 
 	// Validate file size
-	if header.Size > MaxProfilePictureSize {
-		http.Error(w, "File too large. Maximum size is 5MB", http.StatusBadRequest)
+	if header.Size > h.MaxProfilePictureSize {
+		http.Error(w, fmt.Sprintf("File too large. Maximum size is %s", config.ByteSize(h.MaxProfilePictureSize)), http.StatusBadRequest)
 		return
 	}
 
@@ -107,6 +118,103 @@ func (h *MediaHandler) HandleUploadProfilePicture(w http.ResponseWriter, r *http
 	})
 }
 
+// HandleUploadChatAvatar handles group chat avatar uploads. Avatars are
+// processed like profile pictures and only admins can change them.
+func (h *MediaHandler) HandleUploadChatAvatar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Get chat ID from URL
+	vars := mux.Vars(r)
+	chatID, err := strconv.Atoi(vars["chatID"])
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is an admin of the chat
+	isMember, role, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+	if role != "admin" {
+		http.Error(w, "Only chat admins can change chat settings", http.StatusForbidden)
+		return
+	}
+
+	chat, err := h.DB.GetChatByID(chatID)
+	if err != nil {
+		http.Error(w, "Failed to get chat", http.StatusInternalServerError)
+		return
+	}
+	if chat.Type != "group" {
+		http.Error(w, "Only group chats have avatars", http.StatusBadRequest)
+		return
+	}
+
+	// Parse multipart form
+	err = r.ParseMultipartForm(h.MaxProfilePictureSize)
+	if err != nil {
+		http.Error(w, "Failed to parse form or file too large", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		http.Error(w, "No file provided", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	// Validate file type
+	if !isValidImageType(header.Header.Get("Content-Type")) {
+		http.Error(w, "Invalid file type. Only JPEG, PNG, and GIF are allowed", http.StatusBadRequest)
+		return
+	}
+
+	// Validate file size
+	if header.Size > h.MaxProfilePictureSize {
+		http.Error(w, fmt.Sprintf("File too large. Maximum size is %s", config.ByteSize(h.MaxProfilePictureSize)), http.StatusBadRequest)
+		return
+	}
+
+	// Process and save the image
+	filename, err := h.processAndSaveProfilePicture(file, header.Filename, userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to process image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Update chat avatar in database
+	avatarURL := fmt.Sprintf("/api/media/profiles/%s", filename)
+	err = h.DB.UpdateChatAvatar(chatID, avatarURL)
+	if err != nil {
+		// Clean up file if database update fails
+		os.Remove(filepath.Join(h.UploadDir, "profiles", filename))
+		http.Error(w, "Failed to update chat", http.StatusInternalServerError)
+		return
+	}
+
+	chat, err = h.DB.GetChatByID(chatID)
+	if err != nil {
+		http.Error(w, "Chat updated but failed to retrieve", http.StatusInternalServerError)
+		return
+	}
+
+	publishToChat(h.DB, h.Hub, chatID, newChatUpdatedEvent(chat))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chat)
+}
+
 // HandleUploadMedia handles general media file uploads
 func (h *MediaHandler) HandleUploadMedia(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("user_id").(int)
@@ -115,8 +223,8 @@ func (h *MediaHandler) HandleUploadMedia(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Parse multipart form (50MB max)
-	err := r.ParseMultipartForm(50 << 20)
+	// Parse multipart form
+	err := r.ParseMultipartForm(h.MaxUploadSize)
 	if err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
@@ -135,9 +243,9 @@ func (h *MediaHandler) HandleUploadMedia(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Validate file size (50MB max)
-	if header.Size > 50*1024*1024 {
-		http.Error(w, "File too large. Maximum size is 50MB", http.StatusBadRequest)
+	// Validate file size
+	if header.Size > h.MaxUploadSize {
+		http.Error(w, fmt.Sprintf("File too large. Maximum size is %s", config.ByteSize(h.MaxUploadSize)), http.StatusBadRequest)
 		return
 	}
 
@@ -219,6 +327,11 @@ func (h *MediaHandler) HandleServeMedia(w http.ResponseWriter, r *http.Request)
 	}
 
 	if err != nil {
+		// Files whose last message was deleted are gone
+		if errors.Is(err, db.ErrMediaFileNotFound) {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Failed to verify access", http.StatusInternalServerError)
 		return
 	}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
@@ -13,21 +14,36 @@ import (
 	"strings"
 	"time"
 
+	"OurChat/internal/config"
 	"OurChat/internal/db"
 	"OurChat/internal/models"
+	"OurChat/internal/realtime"
 
 	"github.com/gorilla/mux"
 )
 
 // MessageHandler contains handlers related to chat messages
 type MessageHandler struct {
-	DB *db.DB
+	DB  *db.DB
+	Hub *realtime.Hub
+	// How long after sending a message its sender can still edit it
+	EditWindow time.Duration
+	// Where media messages are stored and the largest accepted file in bytes
+	UploadDir     string
+	MaxUploadSize int64
 }
 
-// NewMessageHandler creates a new message handler
-func NewMessageHandler(db *db.DB) *MessageHandler {
+// DefaultEditWindow is the edit window used unless configured otherwise
+const DefaultEditWindow = 48 * time.Hour
+
+// NewMessageHandler creates a new message handler storing media in uploadDir
+func NewMessageHandler(db *db.DB, hub *realtime.Hub, uploadDir string) *MessageHandler {
 	return &MessageHandler{
-		DB: db,
+		DB:            db,
+		Hub:           hub,
+		EditWindow:    DefaultEditWindow,
+		UploadDir:     uploadDir,
+		MaxUploadSize: MaxUploadSize,
 	}
 }
 
@@ -77,22 +93,96 @@ func (h *MessageHandler) HandleGetMessages(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Get messages with media file information
-	messages, err := h.DB.GetMessagesByChatIDWithMedia(chatID, limit, offset)
+	// Cursor parameters return a page with cursors instead of a plain list
+	cursor, usesCursor, err := parseMessageCursor(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !usesCursor {
+		// Get messages with media file information
+		messages, err := h.DB.GetMessagesByChatIDWithMedia(chatID, userID, limit, offset)
+		if err != nil {
+			http.Error(w, "Failed to get messages", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+		return
+	}
+
+	if limit > maxMessagePageLimit {
+		limit = maxMessagePageLimit
+	}
+
+	// The message to jump to has to be in this chat
+	if cursor.AroundID > 0 {
+		message, err := h.DB.GetMessageByIDWithMedia(cursor.AroundID)
+		if err != nil || message.ChatID != chatID {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	page, err := h.DB.GetMessagesPage(chatID, userID, cursor, limit)
 	if err != nil {
 		http.Error(w, "Failed to get messages", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(page)
+}
+
+// Maximum number of messages in a cursor page
+const maxMessagePageLimit = 100
+
+// parseMessageCursor reads the before_id, after_id and around_id query
+// parameters, reporting whether any of them was given. before_id=0 asks for
+// the newest messages.
+func parseMessageCursor(r *http.Request) (db.MessageCursor, bool, error) {
+	var cursor db.MessageCursor
+	params := r.URL.Query()
+
+	given := 0
+	for _, param := range []struct {
+		name string
+		dest *int
+	}{
+		{"before_id", &cursor.BeforeID},
+		{"after_id", &cursor.AfterID},
+		{"around_id", &cursor.AroundID},
+	} {
+		if !params.Has(param.name) {
+			continue
+		}
+		given++
+
+		id, err := strconv.Atoi(params.Get(param.name))
+		if err != nil || id < 0 {
+			return cursor, false, fmt.Errorf("Invalid %s", param.name)
+		}
+		*param.dest = id
+	}
+
+	if given > 1 {
+		return cursor, false, fmt.Errorf("Only one of before_id, after_id and around_id can be used")
+	}
+	if given == 1 && params.Has("offset") {
+		return cursor, false, fmt.Errorf("offset can't be combined with a cursor")
+	}
+
+	return cursor, given == 1, nil
 }
 
 // HandleSendMessage sends a message to a specific chat
 type MessageRequest struct {
-	Content     string `json:"content,omitempty"`
-	MessageType string `json:"message_type"`
-	MediaFileID *int   `json:"media_file_id,omitempty"`
+	Content          string `json:"content,omitempty"`
+	MessageType      string `json:"message_type"`
+	MediaFileID      *int   `json:"media_file_id,omitempty"`
+	ReplyToMessageID *int   `json:"reply_to_message_id,omitempty"`
 }
 
 // REPLACE your existing HandleSendMessage function with this:
@@ -158,8 +248,20 @@ func (h *MessageHandler) HandleSendMessage(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !checkChatActive(w, h.DB, chatID) {
+		return
+	}
+
+	// Verify the replied-to message
+	if req.ReplyToMessageID != nil {
+		if err := h.validateReplyTarget(chatID, *req.ReplyToMessageID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Create message
-	messageID, err := h.DB.CreateMessage(userID, chatID, req.Content, req.MessageType, req.MediaFileID)
+	messageID, err := h.DB.CreateMessage(userID, chatID, req.Content, req.MessageType, req.MediaFileID, req.ReplyToMessageID)
 	if err != nil {
 		http.Error(w, "Failed to send message", http.StatusInternalServerError)
 		return
@@ -172,12 +274,321 @@ func (h *MessageHandler) HandleSendMessage(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Push the new message to connected chat members
+	h.publishMessage(message)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(message)
 }
 
-// HandleMarkMessagesAsRead marks all messages in a chat as read
+// EditMessageRequest represents a request to edit a text message
+type EditMessageRequest struct {
+	Content string `json:"content"`
+}
+
+// HandleEditMessage replaces the content of a text message sent by the current user
+func (h *MessageHandler) HandleEditMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, messageID, err := parseMessageVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Content) == "" {
+		http.Error(w, "Text message content is required", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, _, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	if !checkChatActive(w, h.DB, chatID) {
+		return
+	}
+
+	// Get the message and make sure it belongs to this chat
+	message, err := h.DB.GetMessageByIDWithMedia(messageID)
+	if err != nil || message.ChatID != chatID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	// Only the sender can edit, and only text messages
+	if message.SenderID != userID {
+		http.Error(w, "You can only edit your own messages", http.StatusForbidden)
+		return
+	}
+
+	if message.DeletedAt != nil {
+		http.Error(w, "Message has been deleted", http.StatusBadRequest)
+		return
+	}
+
+	if message.MessageType != "text" {
+		http.Error(w, "Only text messages can be edited", http.StatusBadRequest)
+		return
+	}
+
+	if time.Since(message.CreatedAt) > h.EditWindow {
+		http.Error(w, "Message can no longer be edited", http.StatusForbidden)
+		return
+	}
+
+	if req.Content == message.Content {
+		// Nothing changed, don't record a revision
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(message)
+		return
+	}
+
+	if err := h.DB.EditMessage(messageID, userID, req.Content); err != nil {
+		http.Error(w, "Failed to edit message", http.StatusInternalServerError)
+		return
+	}
+
+	// Get the updated message
+	message, err = h.DB.GetMessageByIDWithMedia(messageID)
+	if err != nil {
+		http.Error(w, "Message edited but failed to retrieve", http.StatusInternalServerError)
+		return
+	}
+
+	publishToChat(h.DB, h.Hub, chatID, &realtime.Event{
+		Type:   realtime.EventMessageEdited,
+		ChatID: chatID,
+		Data:   message,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// HandleGetMessageEdits lists the previous revisions of a message.
+// Only chat admins and the sender of the message can see them.
+func (h *MessageHandler) HandleGetMessageEdits(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, messageID, err := parseMessageVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, role, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	message, err := h.DB.GetMessageByIDWithMedia(messageID)
+	if err != nil || message.ChatID != chatID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if role != "admin" && message.SenderID != userID {
+		http.Error(w, "Only chat admins can view edit history", http.StatusForbidden)
+		return
+	}
+
+	edits, err := h.DB.GetMessageEdits(messageID)
+	if err != nil {
+		http.Error(w, "Failed to get message edits", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": message,
+		"edits":   edits,
+	})
+}
+
+// HandleGetThread returns a message together with all replies to it
+func (h *MessageHandler) HandleGetThread(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, messageID, err := parseMessageVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, _, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	root, err := h.DB.GetMessageByIDWithMedia(messageID)
+	if err != nil || root.ChatID != chatID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	replies, err := h.DB.GetRepliesWithMedia(messageID, userID)
+	if err != nil {
+		http.Error(w, "Failed to get replies", http.StatusInternalServerError)
+		return
+	}
+
+	// Tombstones stay in the thread but aren't counted, like in root.ReplyCount
+	replyCount := 0
+	for _, reply := range replies {
+		if reply.DeletedAt == nil {
+			replyCount++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"root":        root,
+		"replies":     replies,
+		"reply_count": replyCount,
+	})
+}
+
+// HandleDeleteMessage deletes a message. By default the message is deleted for
+// everyone and replaced by a tombstone, which requires being its sender or a
+// chat admin. With ?scope=me it is only hidden for the current user.
+func (h *MessageHandler) HandleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, messageID, err := parseMessageVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scope := r.URL.Query().Get("scope")
+	if scope == "" {
+		scope = "everyone"
+	}
+	if scope != "everyone" && scope != "me" {
+		http.Error(w, "Invalid scope", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, role, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	// Get the message and make sure it belongs to this chat
+	message, err := h.DB.GetMessageByIDWithMedia(messageID)
+	if err != nil || message.ChatID != chatID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if scope == "me" {
+		if err := h.DB.HideMessage(messageID, userID); err != nil {
+			http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Hiding a message only affects the user, deleting it changes the chat
+	if !checkChatActive(w, h.DB, chatID) {
+		return
+	}
+
+	if message.SenderID != userID && role != "admin" {
+		http.Error(w, "You can only delete your own messages", http.StatusForbidden)
+		return
+	}
+
+	if message.DeletedAt == nil {
+		orphanedPath, err := h.DB.DeleteMessage(messageID, userID)
+		if err != nil {
+			http.Error(w, "Failed to delete message", http.StatusInternalServerError)
+			return
+		}
+
+		if orphanedPath != "" {
+			if err := os.Remove(orphanedPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Failed to remove media file %s: %v", orphanedPath, err)
+			}
+		}
+
+		// Get the tombstone
+		message, err = h.DB.GetMessageByIDWithMedia(messageID)
+		if err != nil {
+			http.Error(w, "Message deleted but failed to retrieve", http.StatusInternalServerError)
+			return
+		}
+
+		publishToChat(h.DB, h.Hub, chatID, &realtime.Event{
+			Type:   realtime.EventMessageDeleted,
+			ChatID: chatID,
+			Data:   message,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// MarkReadRequest represents a request to mark messages as read
+type MarkReadRequest struct {
+	// Messages up to and including this one are marked as read. If omitted,
+	// every message in the chat is.
+	UpToMessageID int `json:"up_to_message_id,omitempty"`
+}
+
+// HandleMarkMessagesAsRead marks the messages in a chat as read for the current user
 func (h *MessageHandler) HandleMarkMessagesAsRead(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by auth middleware)
 	userID, ok := r.Context().Value("user_id").(int)
@@ -195,21 +606,122 @@ func (h *MessageHandler) HandleMarkMessagesAsRead(w http.ResponseWriter, r *http
 		return
 	}
 
-	// TODO: Check if user is a member of the chat
+	// The request body is optional
+	var req MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, _, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	// The cursor must point at a message of this chat
+	if req.UpToMessageID != 0 {
+		message, err := h.DB.GetMessageByIDWithMedia(req.UpToMessageID)
+		if err != nil || message.ChatID != chatID {
+			http.Error(w, "Message not found in this chat", http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Mark messages as read
-	err = h.DB.MarkMessagesAsRead(userID, chatID)
+	lastReadMessageID, err := h.DB.MarkMessagesAsRead(userID, chatID, req.UpToMessageID)
 	if err != nil {
 		http.Error(w, "Failed to mark messages as read", http.StatusInternalServerError)
 		return
 	}
 
+	// Let the other members know how far the user caught up
+	publishToChat(h.DB, h.Hub, chatID, &realtime.Event{
+		Type:   realtime.EventMessagesRead,
+		ChatID: chatID,
+		Data: map[string]interface{}{
+			"user_id":              userID,
+			"read_at":              time.Now(),
+			"last_read_message_id": lastReadMessageID,
+		},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":              "Messages marked as read",
+		"last_read_message_id": lastReadMessageID,
+	})
+}
+
+// HandleGetMessageReads lists the members who read a message
+func (h *MessageHandler) HandleGetMessageReads(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, messageID, err := parseMessageVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, _, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	message, err := h.DB.GetMessageByIDWithMedia(messageID)
+	if err != nil || message.ChatID != chatID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	members, err := h.DB.GetChatMembers(chatID)
+	if err != nil {
+		http.Error(w, "Failed to get chat members", http.StatusInternalServerError)
+		return
+	}
+
+	// A member read the message once their read cursor reached it
+	readers := make([]map[string]interface{}, 0)
+	for _, member := range members {
+		if member.UserID == message.SenderID || member.LastReadMessageID == nil || *member.LastReadMessageID < messageID {
+			continue
+		}
+		readers = append(readers, map[string]interface{}{
+			"user_id":      member.UserID,
+			"username":     member.Username,
+			"last_read_at": member.LastReadAt,
+		})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "Messages marked as read",
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": messageID,
+		"read_by":    readers,
+		"count":      len(readers),
 	})
 }
 
+// Default and maximum number of search results per page
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
 // HandleSearchMessages searches for messages in a chat
 func (h *MessageHandler) HandleSearchMessages(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by auth middleware)
@@ -228,13 +740,6 @@ func (h *MessageHandler) HandleSearchMessages(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Get search query
-	query := r.URL.Query().Get("q")
-	if query == "" {
-		http.Error(w, "Search query is required", http.StatusBadRequest)
-		return
-	}
-
 	// Check if user is a member of the chat
 	isMember, _, err := h.DB.IsUserChatMember(userID, chatID)
 	if err != nil {
@@ -246,16 +751,142 @@ func (h *MessageHandler) HandleSearchMessages(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	h.writeSearchResults(w, r, userID, chatID)
+}
+
+// HandleSearchAllMessages searches for messages across every chat the user
+// belongs to
+func (h *MessageHandler) HandleSearchAllMessages(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	h.writeSearchResults(w, r, userID, 0)
+}
+
+// writeSearchResults runs the search described by the query parameters and
+// responds with the results. A chat ID of 0 searches every chat of the user.
+func (h *MessageHandler) writeSearchResults(w http.ResponseWriter, r *http.Request, userID, chatID int) {
+	params := r.URL.Query()
+	query := strings.TrimSpace(params.Get("q"))
+
+	options := db.SearchOptions{
+		ChatID: chatID,
+		Limit:  defaultSearchLimit,
+	}
+
+	// Parse pagination parameters
+	if limitStr := params.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err == nil && parsed > 0 {
+			options.Limit = parsed
+		}
+	}
+	if options.Limit > maxSearchLimit {
+		options.Limit = maxSearchLimit
+	}
+
+	if offsetStr := params.Get("offset"); offsetStr != "" {
+		parsed, err := strconv.Atoi(offsetStr)
+		if err == nil && parsed >= 0 {
+			options.Offset = parsed
+		}
+	}
+
+	// Parse filters
+	if senderStr := params.Get("sender_id"); senderStr != "" {
+		senderID, err := strconv.Atoi(senderStr)
+		if err != nil || senderID <= 0 {
+			http.Error(w, "Invalid sender ID", http.StatusBadRequest)
+			return
+		}
+		options.SenderID = senderID
+	}
+
+	if sinceStr := params.Get("since"); sinceStr != "" {
+		since, _, err := parseSearchDate(sinceStr)
+		if err != nil {
+			http.Error(w, "Invalid since date, use RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		options.Since = &since
+	}
+
+	if untilStr := params.Get("until"); untilStr != "" {
+		until, dateOnly, err := parseSearchDate(untilStr)
+		if err != nil {
+			http.Error(w, "Invalid until date, use RFC 3339 or YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		// A plain date includes the whole day
+		if dateOnly {
+			until = until.AddDate(0, 0, 1)
+		}
+		options.Until = &until
+	}
+
+	if options.Since != nil && options.Until != nil && !options.Since.Before(*options.Until) {
+		http.Error(w, "since must be before until", http.StatusBadRequest)
+		return
+	}
+
+	options.MessageType = params.Get("type")
+	if options.MessageType != "" && options.MessageType != "text" && options.MessageType != "media" {
+		http.Error(w, "Type must be text or media", http.StatusBadRequest)
+		return
+	}
+
+	options.MediaCategory = params.Get("media")
+	if options.MediaCategory != "" && !db.IsValidMediaCategory(options.MediaCategory) {
+		http.Error(w, "Media must be image, video, audio or document", http.StatusBadRequest)
+		return
+	}
+
+	if hasLinkStr := params.Get("has_link"); hasLinkStr != "" {
+		hasLink, err := strconv.ParseBool(hasLinkStr)
+		if err != nil {
+			http.Error(w, "Invalid has_link value", http.StatusBadRequest)
+			return
+		}
+		options.HasLink = hasLink
+	}
+
+	// Searching without any text or filter would just list every message
+	hasFilter := options.SenderID != 0 || options.Since != nil || options.Until != nil ||
+		options.MessageType != "" || options.MediaCategory != "" || options.HasLink
+	if query == "" && !hasFilter {
+		http.Error(w, "Search query or filter is required", http.StatusBadRequest)
+		return
+	}
+
 	// Search messages
-	messages, err := h.DB.SearchMessages(chatID, query)
+	results, err := h.DB.SearchMessages(userID, query, options)
 	if err != nil {
 		http.Error(w, "Failed to search messages", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(messages)
+	json.NewEncoder(w).Encode(results)
 }
+
+// parseSearchDate parses an RFC 3339 timestamp or a plain YYYY-MM-DD date in
+// UTC, reporting which of the two it was
+func parseSearchDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t, true, nil
+}
+
 func (h *MessageHandler) HandleSendMediaMessage(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("user_id").(int)
 	if !ok {
@@ -283,8 +914,12 @@ func (h *MessageHandler) HandleSendMediaMessage(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Parse multipart form (50MB max)
-	err = r.ParseMultipartForm(50 << 20)
+	if !checkChatActive(w, h.DB, chatID) {
+		return
+	}
+
+	// Parse multipart form
+	err = r.ParseMultipartForm(h.MaxUploadSize)
 	if err != nil {
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
@@ -293,6 +928,21 @@ func (h *MessageHandler) HandleSendMediaMessage(w http.ResponseWriter, r *http.R
 	// Get optional caption
 	caption := r.FormValue("caption")
 
+	// Get optional replied-to message
+	var replyToMessageID *int
+	if replyToStr := r.FormValue("reply_to_message_id"); replyToStr != "" {
+		replyToID, err := strconv.Atoi(replyToStr)
+		if err != nil {
+			http.Error(w, "Invalid reply_to_message_id", http.StatusBadRequest)
+			return
+		}
+		if err := h.validateReplyTarget(chatID, replyToID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		replyToMessageID = &replyToID
+	}
+
 	// Get the file
 	file, header, err := r.FormFile("media")
 	if err != nil {
@@ -307,9 +957,9 @@ func (h *MessageHandler) HandleSendMediaMessage(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Validate file size (50MB max)
-	if header.Size > 50*1024*1024 {
-		http.Error(w, "File too large. Maximum size is 50MB", http.StatusBadRequest)
+	// Validate file size
+	if header.Size > h.MaxUploadSize {
+		http.Error(w, fmt.Sprintf("File too large. Maximum size is %s", config.ByteSize(h.MaxUploadSize)), http.StatusBadRequest)
 		return
 	}
 
@@ -321,7 +971,7 @@ func (h *MessageHandler) HandleSendMediaMessage(w http.ResponseWriter, r *http.R
 	}
 
 	// Create the message with media
-	messageID, err := h.DB.CreateMessage(userID, chatID, caption, "media", &mediaFileID)
+	messageID, err := h.DB.CreateMessage(userID, chatID, caption, "media", &mediaFileID, replyToMessageID)
 	if err != nil {
 		http.Error(w, "Failed to send message", http.StatusInternalServerError)
 		return
@@ -334,19 +984,58 @@ func (h *MessageHandler) HandleSendMediaMessage(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	// Push the new message to connected chat members
+	h.publishMessage(message)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(message)
 }
 
 // Helper functions for the message handler
+
+// parseMessageVars reads the chat and message IDs from the URL
+func parseMessageVars(r *http.Request) (int, int, error) {
+	vars := mux.Vars(r)
+	chatID, err := strconv.Atoi(vars["chatID"])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid chat ID")
+	}
+
+	messageID, err := strconv.Atoi(vars["messageID"])
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid message ID")
+	}
+
+	return chatID, messageID, nil
+}
+
+// validateReplyTarget checks that a message can be replied to in the chat
+func (h *MessageHandler) validateReplyTarget(chatID, messageID int) error {
+	message, err := h.DB.GetMessageByIDWithMedia(messageID)
+	if err != nil || message.ChatID != chatID {
+		return fmt.Errorf("Replied-to message not found in this chat")
+	}
+	if message.DeletedAt != nil {
+		return fmt.Errorf("Cannot reply to a deleted message")
+	}
+	// Threads are one level deep
+	if message.ReplyToMessageID != nil {
+		return fmt.Errorf("Cannot reply to a reply")
+	}
+	return nil
+}
+
+func (h *MessageHandler) publishMessage(message *models.Message) {
+	publishToChat(h.DB, h.Hub, message.ChatID, newMessageEvent(message))
+}
+
 func (h *MessageHandler) saveMediaFile(file multipart.File, header *multipart.FileHeader, userID int) (int, error) {
-	uploadDir := "./uploads"
-	os.MkdirAll(filepath.Join(uploadDir, "media"), 0755)
+	os.MkdirAll(filepath.Join(h.UploadDir, "media"), 0755)
 
 	// Generate unique filename
 	filename := h.generateUniqueFilename(header.Filename, userID)
-	filePath := filepath.Join(uploadDir, "media", filename)
+	filePath := filepath.Join(h.UploadDir, "media", filename)
 
 	// Save file
 	dst, err := os.Create(filePath)
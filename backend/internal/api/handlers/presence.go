@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"OurChat/internal/db"
+	"OurChat/internal/presence"
+
+	"github.com/gorilla/mux"
+)
+
+// PresenceHandler contains handlers for ephemeral presence state
+type PresenceHandler struct {
+	DB       *db.DB
+	Presence *presence.Service
+}
+
+// NewPresenceHandler creates a new presence handler
+func NewPresenceHandler(db *db.DB, presence *presence.Service) *PresenceHandler {
+	return &PresenceHandler{
+		DB:       db,
+		Presence: presence,
+	}
+}
+
+// TypingRequest represents a typing report from a client
+type TypingRequest struct {
+	Typing bool `json:"typing"`
+}
+
+// HandleSetTyping reports that the current user started or stopped typing in a chat
+func (h *PresenceHandler) HandleSetTyping(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Get chat ID from URL
+	vars := mux.Vars(r)
+	chatIDStr := vars["chatID"]
+	chatID, err := strconv.Atoi(chatIDStr)
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	var req TypingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, _, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	if !checkChatActive(w, h.DB, chatID) {
+		return
+	}
+
+	h.Presence.SetTyping(chatID, userID, req.Typing)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleGetTyping lists the users currently typing in a chat
+func (h *PresenceHandler) HandleGetTyping(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Get chat ID from URL
+	vars := mux.Vars(r)
+	chatIDStr := vars["chatID"]
+	chatID, err := strconv.Atoi(chatIDStr)
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, _, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chat_id":  chatID,
+		"user_ids": h.Presence.TypingUsers(chatID),
+	})
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"OurChat/internal/models"
+	"OurChat/internal/realtime"
+
+	"github.com/gorilla/mux"
+)
+
+// Maximum size of a unicode reaction in bytes, enough for ZWJ sequences
+const maxReactionBytes = 64
+
+// Short codes such as :thumbsup: are accepted alongside unicode emoji
+var reactionShortCode = regexp.MustCompile(`^:[a-z0-9_+-]{1,32}:$`)
+
+// ReactionRequest represents a request to react to a message
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// HandleAddReaction adds the current user's reaction to a message
+func (h *MessageHandler) HandleAddReaction(w http.ResponseWriter, r *http.Request) {
+	var req ReactionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	h.handleReaction(w, r, req.Emoji, true)
+}
+
+// HandleRemoveReaction removes the current user's reaction from a message
+func (h *MessageHandler) HandleRemoveReaction(w http.ResponseWriter, r *http.Request) {
+	h.handleReaction(w, r, mux.Vars(r)["emoji"], false)
+}
+
+// handleReaction adds or removes a reaction and responds with the updated
+// reactions of the message
+func (h *MessageHandler) handleReaction(w http.ResponseWriter, r *http.Request, emoji string, add bool) {
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, messageID, err := parseMessageVars(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !isValidReaction(emoji) {
+		http.Error(w, "Reaction must be a single emoji or a short code like :thumbsup:", http.StatusBadRequest)
+		return
+	}
+
+	// Check if user is a member of the chat
+	isMember, _, err := h.DB.IsUserChatMember(userID, chatID)
+	if err != nil {
+		http.Error(w, "Failed to verify chat membership", http.StatusInternalServerError)
+		return
+	}
+	if !isMember {
+		http.Error(w, "You are not a member of this chat", http.StatusForbidden)
+		return
+	}
+
+	if !checkChatActive(w, h.DB, chatID) {
+		return
+	}
+
+	message, err := h.DB.GetMessageByIDWithMedia(messageID)
+	if err != nil || message.ChatID != chatID {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+
+	if add && message.DeletedAt != nil {
+		http.Error(w, "Cannot react to a deleted message", http.StatusBadRequest)
+		return
+	}
+
+	var changed bool
+	if add {
+		changed, err = h.DB.AddReaction(messageID, userID, emoji)
+	} else {
+		changed, err = h.DB.RemoveReaction(messageID, userID, emoji)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update reaction", http.StatusInternalServerError)
+		return
+	}
+
+	// Only announce reactions that actually changed
+	if changed {
+		publishToChat(h.DB, h.Hub, chatID, &realtime.Event{
+			Type:   realtime.EventReaction,
+			ChatID: chatID,
+			Data: map[string]interface{}{
+				"message_id": messageID,
+				"user_id":    userID,
+				"emoji":      emoji,
+				"reacted":    add,
+			},
+		})
+	}
+
+	summaries, err := h.DB.GetReactionSummaries([]int{messageID}, userID)
+	if err != nil {
+		http.Error(w, "Failed to get reactions", http.StatusInternalServerError)
+		return
+	}
+
+	reactions := summaries[messageID]
+	if reactions == nil {
+		reactions = []models.ReactionSummary{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message_id": messageID,
+		"reactions":  reactions,
+	})
+}
+
+// isValidReaction accepts a short code or a single unicode emoji, including
+// modifier, keycap, flag and ZWJ sequences, but no text or runs of emoji
+func isValidReaction(emoji string) bool {
+	if reactionShortCode.MatchString(emoji) {
+		return true
+	}
+
+	if emoji == "" || len(emoji) > maxReactionBytes || !utf8.ValidString(emoji) {
+		return false
+	}
+
+	// A ZWJ sequence joins emoji into one, so a reaction is one or more emoji
+	// with a ZWJ between each of them
+	runes := []rune(emoji)
+	for {
+		n := emojiLength(runes)
+		if n == 0 {
+			return false
+		}
+		runes = runes[n:]
+		if len(runes) == 0 {
+			return true
+		}
+		if runes[0] != zeroWidthJoiner {
+			return false
+		}
+		runes = runes[1:]
+	}
+}
+
+const (
+	zeroWidthJoiner   = '\u200d'
+	variationSelector = '\ufe0f'
+	combiningKeycap   = '\u20e3'
+	blackFlag         = '\U0001f3f4'
+	cancelTag         = '\U000e007f'
+)
+
+// emojiLength returns the number of runes in the emoji that runes start with,
+// or 0 if they don't start with one
+func emojiLength(runes []rune) int {
+	if len(runes) == 0 {
+		return 0
+	}
+
+	r := runes[0]
+	switch {
+	case isRegionalIndicator(r):
+		// Flags are pairs of regional indicators
+		if len(runes) >= 2 && isRegionalIndicator(runes[1]) {
+			return 2
+		}
+		return 0
+
+	case r < utf8.RuneSelf:
+		// Only keycap bases are allowed from ASCII, and only in a keycap
+		if !unicode.IsDigit(r) && r != '#' && r != '*' {
+			return 0
+		}
+		n := 1
+		if n < len(runes) && runes[n] == variationSelector {
+			n++
+		}
+		if n < len(runes) && runes[n] == combiningKeycap {
+			return n + 1
+		}
+		return 0
+
+	case unicode.Is(unicode.So, r):
+		n := 1
+		if n < len(runes) && (runes[n] == variationSelector || isSkinToneModifier(runes[n])) {
+			n++
+		}
+
+		// Subdivision flags are a black flag followed by tags
+		if r == blackFlag && n < len(runes) && isTag(runes[n]) {
+			for n < len(runes) && isTag(runes[n]) {
+				n++
+			}
+			if n < len(runes) && runes[n] == cancelTag {
+				return n + 1
+			}
+			return 0
+		}
+		return n
+	}
+
+	return 0
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= '\U0001f1e6' && r <= '\U0001f1ff'
+}
+
+func isSkinToneModifier(r rune) bool {
+	return r >= '\U0001f3fb' && r <= '\U0001f3ff'
+}
+
+func isTag(r rune) bool {
+	return r >= '\U000e0020' && r <= '\U000e007e'
+}
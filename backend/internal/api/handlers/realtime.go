@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"OurChat/internal/db"
+	"OurChat/internal/models"
+	"OurChat/internal/realtime"
+
+	"github.com/gorilla/websocket"
+)
+
+// RealtimeHandler contains handlers for realtime event delivery
+type RealtimeHandler struct {
+	DB       *db.DB
+	Hub      *realtime.Hub
+	upgrader websocket.Upgrader
+}
+
+// NewRealtimeHandler creates a new realtime handler
+func NewRealtimeHandler(db *db.DB, hub *realtime.Hub) *RealtimeHandler {
+	return &RealtimeHandler{
+		DB:  db,
+		Hub: hub,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// Requests are authenticated with a bearer token, not cookies,
+			// so cross-origin upgrades can't ride on a user's session
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+}
+
+// HandleWebSocket upgrades the connection and streams chat events to the user
+func (h *RealtimeHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Kept so logging out or revoking the session closes the connection
+	sessionID, _ := r.Context().Value("session_id").(int)
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already wrote an error response
+		log.Printf("WebSocket upgrade failed for user %d: %v", userID, err)
+		return
+	}
+
+	h.Hub.ServeWebSocket(conn, userID, sessionID)
+}
+
+// Maximum number of missed messages replayed when an SSE client resumes
+const sseReplayLimit = 500
+
+// HandleEvents streams chat events to the user as Server-Sent Events. This is
+// the fallback for clients behind proxies that don't support WebSockets.
+func (h *RealtimeHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sessionID, _ := r.Context().Value("session_id").(int)
+
+	// Browsers send Last-Event-ID on reconnect; allow a query parameter for
+	// clients that open a fresh EventSource
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	resuming := lastEventID != ""
+
+	var afterID int
+	var lastSeq int64
+	var hasSeq bool
+	if resuming {
+		var err error
+		afterID, lastSeq, hasSeq, err = realtime.ParseSSEPosition(lastEventID)
+		if err != nil {
+			http.Error(w, "Invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	} else {
+		// A fresh stream starts at the newest message
+		latestID, err := h.DB.GetLatestMessageIDForUser(userID)
+		if err != nil {
+			log.Printf("Failed to get latest message for user %d: %v", userID, err)
+			http.Error(w, "Failed to open event stream", http.StatusInternalServerError)
+			return
+		}
+		afterID = latestID
+	}
+
+	// The stream outlives the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear SSE write deadline for user %d: %v", userID, err)
+	}
+
+	stream, err := realtime.NewSSEStream(w)
+	if err != nil {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// Replay the messages the client missed while disconnected. This happens
+	// before registering, so live events can't pile up in the client's
+	// buffer while a long replay is written.
+	resync := false
+	if resuming {
+		stream.SetPosition(afterID, lastSeq)
+		afterID, resync, err = h.replayMessages(stream, userID, afterID)
+		if err != nil {
+			return
+		}
+	}
+
+	client := h.Hub.NewClient(userID, sessionID)
+	defer h.Hub.Unregister(client)
+
+	// Catch up on the messages sent before the client was registered
+	if !resync {
+		afterID, resync, err = h.replayMessages(stream, userID, afterID)
+		if err != nil {
+			return
+		}
+	}
+
+	// Edits, deletions, read receipts and the like aren't stored as events,
+	// so a client that missed any has to refetch
+	if resuming && !resync {
+		resync = !hasSeq || h.Hub.MissedStateChanges(userID, lastSeq, client.StartSeq)
+	}
+
+	if resync {
+		latestID, err := h.DB.GetLatestMessageIDForUser(userID)
+		if err != nil {
+			log.Printf("Failed to get latest message for user %d: %v", userID, err)
+			return
+		}
+
+		// Later reconnects resume from the newest message
+		stream.SetPosition(latestID, client.StartSeq)
+		event := &realtime.Event{
+			Type: realtime.EventResync,
+			Data: map[string]string{"reason": "missed events"},
+		}
+		if err := stream.WriteEvent(event); err != nil {
+			return
+		}
+		stream.Serve(r, client, latestID)
+		return
+	}
+
+	stream.SetPosition(afterID, client.StartSeq)
+	if err := stream.WritePosition(); err != nil {
+		return
+	}
+	stream.Serve(r, client, afterID)
+}
+
+// replayMessages writes the messages sent to the user after afterID and
+// returns the ID of the last one. When more than sseReplayLimit were missed
+// nothing is written and tooMany is set, so the client can be told to resync.
+func (h *RealtimeHandler) replayMessages(stream *realtime.SSEStream, userID, afterID int) (lastID int, tooMany bool, err error) {
+	// Fetch one more than the limit to tell whether anything is left out
+	messages, err := h.DB.GetMessagesForUserSince(userID, afterID, sseReplayLimit+1)
+	if err != nil {
+		log.Printf("Failed to replay messages for user %d: %v", userID, err)
+		return 0, false, err
+	}
+
+	if len(messages) > sseReplayLimit {
+		return afterID, true, nil
+	}
+
+	lastID = afterID
+	for i := range messages {
+		if err := stream.WriteEvent(newMessageEvent(&messages[i])); err != nil {
+			return 0, false, err
+		}
+		lastID = messages[i].ID
+	}
+
+	return lastID, false, nil
+}
+
+// newMessageEvent wraps a message in a realtime event
+func newMessageEvent(message *models.Message) *realtime.Event {
+	return &realtime.Event{
+		ID:     message.ID,
+		Type:   realtime.EventMessageNew,
+		ChatID: message.ChatID,
+		Data:   message,
+	}
+}
+
+// newChatUpdatedEvent wraps a chat whose settings changed in a realtime event
+func newChatUpdatedEvent(chat *models.Chat) *realtime.Event {
+	return &realtime.Event{
+		Type:   realtime.EventChatUpdated,
+		ChatID: chat.ID,
+		Data:   chat,
+	}
+}
+
+// publishToChat sends an event to every member of a chat
+func publishToChat(database *db.DB, hub *realtime.Hub, chatID int, event *realtime.Event) {
+	members, err := database.GetChatMembers(chatID)
+	if err != nil {
+		log.Printf("Failed to get members of chat %d for realtime event: %v", chatID, err)
+		return
+	}
+
+	userIDs := make([]int, 0, len(members))
+	for _, member := range members {
+		userIDs = append(userIDs, member.UserID)
+	}
+
+	hub.SendToUsers(userIDs, event)
+}
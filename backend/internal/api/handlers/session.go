@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"OurChat/internal/db"
+
+	"github.com/gorilla/mux"
+)
+
+// RefreshRequest represents the token refresh request body
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// HandleRefresh exchanges a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working.
+func (h *AuthHandler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.RefreshToken == "" {
+		http.Error(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	session, refreshToken, err := h.DB.RotateSession(req.RefreshToken, clientIP(r), r.UserAgent(), h.RefreshTokenLifetime)
+	if err != nil {
+		log.Printf("Token refresh failed: %v", err)
+
+		// Whoever holds the session's current tokens may be the one who
+		// copied the refresh token, so cut them off as well
+		var reused *db.ReusedRefreshTokenError
+		if errors.As(err, &reused) {
+			h.Revocations.SessionRevoked(reused.SessionID)
+			h.Hub.DisconnectSession(reused.SessionID)
+		}
+
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(session.UserID)
+	if err != nil {
+		log.Printf("Failed to get user %d for token refresh: %v", session.UserID, err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	if user.DisabledAt != nil {
+		http.Error(w, "Invalid or expired refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	h.writeTokens(w, user, session, refreshToken, "Token refreshed")
+}
+
+// HandleGetSessions lists the devices the current user is logged in on
+func (h *AuthHandler) HandleGetSessions(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.DB.GetUserSessions(userID)
+	if err != nil {
+		log.Printf("Failed to get sessions: %v", err)
+		http.Error(w, "Failed to get sessions", http.StatusInternalServerError)
+		return
+	}
+
+	// Mark the session this request was made from
+	if sessionID, ok := r.Context().Value("session_id").(int); ok {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == sessionID
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// HandleRevokeSession logs the current user out of one of their sessions
+func (h *AuthHandler) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessionID, err := strconv.Atoi(mux.Vars(r)["sessionID"])
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Revocations.RevokeSession(sessionID, userID); err != nil {
+		http.Error(w, "Session not found or already revoked", http.StatusNotFound)
+		return
+	}
+
+	h.Hub.DisconnectSession(sessionID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleLogoutAll logs the current user out on every device. Rotating the
+// JWT key makes their access tokens invalid right away.
+func (h *AuthHandler) HandleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.DB.RevokeUserSessions(userID); err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := h.DB.UpdateJWTKey(userID); err != nil {
+		log.Printf("Failed to update JWT key: %v", err)
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	h.Hub.DisconnectUser(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Logged out on all devices",
+	})
+}
+
+// clientIP returns the address the request came from. Behind the bundled
+// nginx proxy that is the X-Real-IP header. It is only shown to the user,
+// never trusted for access decisions.
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
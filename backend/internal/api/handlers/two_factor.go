@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"OurChat/internal/api/utils"
+	"OurChat/internal/models"
+	"OurChat/internal/totp"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Issuer shown next to the account in authenticator apps
+const totpIssuer = "OurChat"
+
+// Wrong 2FA codes, recovery codes or passwords a user can enter every
+// twoFactorAttemptWindow, so codes can't be guessed. A correct one resets the
+// count.
+const (
+	twoFactorAttemptLimit  = 5
+	twoFactorAttemptWindow = 15 * time.Minute
+)
+
+// TwoFactorChallengeResponse is returned by logging in with the password of
+// a user with 2FA
+type TwoFactorChallengeResponse struct {
+	MFARequired    bool      `json:"mfa_required"`
+	MFAToken       string    `json:"mfa_token"`
+	MFATokenExpiry time.Time `json:"mfa_token_expires_at"`
+	Message        string    `json:"message"`
+}
+
+// writeTwoFactorChallenge responds with a challenge token for a user who
+// entered the right password but still has to enter a code
+func (h *AuthHandler) writeTwoFactorChallenge(w http.ResponseWriter, user *models.User, deviceName string) {
+	expiresAt := time.Now().Add(h.TwoFactorChallengeLifetime)
+	token, err := utils.GenerateTwoFactorChallengeToken(user, deviceName, h.TwoFactorChallengeLifetime)
+	if err != nil {
+		log.Printf("Failed to generate challenge token: %v", err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	response := TwoFactorChallengeResponse{
+		MFARequired:    true,
+		MFAToken:       token,
+		MFATokenExpiry: expiresAt.UTC().Truncate(time.Second),
+		Message:        "Enter the code from your authenticator app",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// allowTwoFactorAttempt counts an attempt to enter a code or password for the
+// user, responding with 429 and returning false once there were too many
+func (h *AuthHandler) allowTwoFactorAttempt(w http.ResponseWriter, userID int) bool {
+	if ok, retryAfter := h.twoFactorLimiter.Allow(strconv.Itoa(userID)); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+// twoFactorAttemptSucceeded stops counting the user's earlier wrong attempts
+func (h *AuthHandler) twoFactorAttemptSucceeded(userID int) {
+	h.twoFactorLimiter.Reset(strconv.Itoa(userID))
+}
+
+// LoginTwoFactorRequest represents the second step of logging in with 2FA.
+// Either a code or a recovery code is required.
+type LoginTwoFactorRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// HandleLoginTwoFactor finishes logging in a user with 2FA, exchanging the
+// challenge token and a code from their authenticator app, or one of their
+// recovery codes, for a session
+func (h *AuthHandler) HandleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var req LoginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.MFAToken == "" || (req.Code == "") == (req.RecoveryCode == "") {
+		http.Error(w, "Challenge token and either a code or a recovery code are required", http.StatusBadRequest)
+		return
+	}
+
+	userID, deviceName, err := utils.ValidateTwoFactorChallengeToken(req.MFAToken, h.DB)
+	if err != nil {
+		log.Printf("Invalid challenge token: %v", err)
+		http.Error(w, "Invalid or expired challenge token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", userID, err)
+		http.Error(w, "Invalid or expired challenge token", http.StatusUnauthorized)
+		return
+	}
+
+	if user.DisabledAt != nil {
+		http.Error(w, "Account is disabled", http.StatusForbidden)
+		return
+	}
+
+	// 2FA may have been turned off since the challenge was issued
+	if user.TOTPEnabledAt == nil {
+		http.Error(w, "Invalid or expired challenge token", http.StatusUnauthorized)
+		return
+	}
+
+	if !h.allowTwoFactorAttempt(w, userID) {
+		return
+	}
+
+	if req.RecoveryCode != "" {
+		if err := h.DB.UseRecoveryCode(userID, req.RecoveryCode); err != nil {
+			log.Printf("Two-factor login failed for user %d: %v", userID, err)
+			http.Error(w, "Invalid recovery code", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		step, ok := totp.Validate(user.TOTPSecret, req.Code, time.Now())
+		if !ok {
+			log.Printf("Invalid two-factor code for user %d", userID)
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+
+		if err := h.DB.UseTOTPStep(userID, step); err != nil {
+			log.Printf("Two-factor login failed for user %d: %v", userID, err)
+			http.Error(w, "Invalid code", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	h.twoFactorAttemptSucceeded(userID)
+	h.startSession(w, r, user, deviceName)
+}
+
+// TwoFactorStatusResponse describes the current user's 2FA settings
+type TwoFactorStatusResponse struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// HandleGetTwoFactor gets whether the current user has 2FA on, and how many
+// unused recovery codes they have
+func (h *AuthHandler) HandleGetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", userID, err)
+		http.Error(w, "Failed to get two-factor status", http.StatusInternalServerError)
+		return
+	}
+
+	count, err := h.DB.CountRecoveryCodes(userID)
+	if err != nil {
+		log.Printf("Failed to count recovery codes: %v", err)
+		http.Error(w, "Failed to get two-factor status", http.StatusInternalServerError)
+		return
+	}
+
+	response := TwoFactorStatusResponse{
+		Enabled:           user.TOTPEnabledAt != nil,
+		EnabledAt:         user.TOTPEnabledAt,
+		RecoveryCodesLeft: count,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleSetupTwoFactor starts enrolling the current user in 2FA. It returns
+// a new secret for their authenticator app, which takes effect once confirmed
+// with HandleConfirmTwoFactor.
+func (h *AuthHandler) HandleSetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", userID, err)
+		http.Error(w, "Failed to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("Failed to generate TOTP secret: %v", err)
+		http.Error(w, "Failed to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.DB.SetTOTPSecret(userID, secret); err != nil {
+		log.Printf("Failed to set TOTP secret: %v", err)
+		http.Error(w, "Failed to set up two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(secret, totpIssuer, user.Username),
+	})
+}
+
+// ConfirmTwoFactorRequest represents the request confirming 2FA enrollment
+type ConfirmTwoFactorRequest struct {
+	Code string `json:"code"`
+}
+
+// HandleConfirmTwoFactor turns on 2FA for the current user once they enter a
+// code from their authenticator app, and returns their recovery codes. The
+// codes are only shown this once.
+func (h *AuthHandler) HandleConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Parse request body
+	var req ConfirmTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", userID, err)
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		http.Error(w, "Set up two-factor authentication first", http.StatusBadRequest)
+		return
+	}
+
+	if !h.allowTwoFactorAttempt(w, userID) {
+		return
+	}
+
+	step, ok := totp.Validate(user.TOTPSecret, req.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+	h.twoFactorAttemptSucceeded(userID)
+
+	codes, err := h.DB.EnableTOTP(userID, step)
+	if err != nil {
+		log.Printf("Failed to enable TOTP: %v", err)
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// PasswordConfirmationRequest represents a request that must be confirmed
+// with the current password
+type PasswordConfirmationRequest struct {
+	Password string `json:"password"`
+}
+
+// checkTwoFactorPassword loads the current user for a change to their 2FA
+// settings, checking that 2FA is on and that they confirmed with their
+// password. It responds with an error and returns nil if not.
+func (h *AuthHandler) checkTwoFactorPassword(w http.ResponseWriter, r *http.Request) *models.User {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil
+	}
+
+	// Parse request body
+	var req PasswordConfirmationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return nil
+	}
+
+	if req.Password == "" {
+		http.Error(w, "Password is required", http.StatusBadRequest)
+		return nil
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", userID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil
+	}
+
+	if user.TOTPEnabledAt == nil {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return nil
+	}
+
+	if !h.allowTwoFactorAttempt(w, userID) {
+		return nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		log.Printf("Invalid password for user %d changing 2FA settings", userID)
+		http.Error(w, "Incorrect password", http.StatusForbidden)
+		return nil
+	}
+	h.twoFactorAttemptSucceeded(userID)
+
+	return user
+}
+
+// HandleDisableTwoFactor turns off 2FA for the current user, which must be
+// confirmed with their current password
+func (h *AuthHandler) HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := h.checkTwoFactorPassword(w, r)
+	if user == nil {
+		return
+	}
+
+	if err := h.DB.DisableTOTP(user.ID); err != nil {
+		log.Printf("Failed to disable TOTP: %v", err)
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// HandleRegenerateRecoveryCodes replaces the current user's recovery codes
+// with new ones, which must be confirmed with their current password
+func (h *AuthHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := h.checkTwoFactorPassword(w, r)
+	if user == nil {
+		return
+	}
+
+	codes, err := h.DB.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		log.Printf("Failed to regenerate recovery codes: %v", err)
+		http.Error(w, "Failed to regenerate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":        "Recovery codes regenerated",
+		"recovery_codes": codes,
+	})
+}
@@ -9,20 +9,66 @@ import (
 	"time"
 
 	"OurChat/internal/db"
+	"OurChat/internal/models"
+	"OurChat/internal/presence"
 )
 
 // UserHandler contains handlers related to user management
 type UserHandler struct {
-	DB *db.DB
+	DB       *db.DB
+	Presence *presence.Service
+	Verifier *EmailVerifier
 }
 
 // NewUserHandler creates a new user handler
-func NewUserHandler(db *db.DB) *UserHandler {
+func NewUserHandler(db *db.DB, presence *presence.Service, verifier *EmailVerifier) *UserHandler {
 	return &UserHandler{
-		DB: db,
+		DB:       db,
+		Presence: presence,
+		Verifier: verifier,
 	}
 }
 
+// ProfileResponse represents the current user's profile, without sensitive
+// fields
+type ProfileResponse struct {
+	ID                int        `json:"id"`
+	Username          string     `json:"username"`
+	Email             string     `json:"email"`
+	EmailVerified     bool       `json:"email_verified"`
+	PendingEmail      string     `json:"pending_email,omitempty"` // New address waiting for verification
+	TwoFactorEnabled  bool       `json:"two_factor_enabled"`
+	ProfilePictureURL *string    `json:"profile_picture_url,omitempty"`
+	Status            string     `json:"status"`
+	CreatedAt         time.Time  `json:"created_at"`
+	LastLogin         *time.Time `json:"last_login,omitempty"`
+	LastSeenAt        *time.Time `json:"last_seen_at,omitempty"`
+	HideLastSeen      bool       `json:"hide_last_seen"`
+}
+
+// newProfileResponse builds the profile of a user
+func (h *UserHandler) newProfileResponse(user *models.User) (*ProfileResponse, error) {
+	pendingEmail, err := h.DB.GetPendingEmail(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProfileResponse{
+		ID:                user.ID,
+		Username:          user.Username,
+		Email:             user.Email,
+		EmailVerified:     user.EmailVerifiedAt != nil,
+		PendingEmail:      pendingEmail,
+		TwoFactorEnabled:  user.TOTPEnabledAt != nil,
+		ProfilePictureURL: user.ProfilePictureURL,
+		Status:            user.Status,
+		CreatedAt:         user.CreatedAt,
+		LastLogin:         user.LastLogin,
+		LastSeenAt:        user.LastSeenAt,
+		HideLastSeen:      user.HideLastSeen,
+	}, nil
+}
+
 // HandleGetProfile gets the current user's profile
 func (h *UserHandler) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by auth middleware)
@@ -40,32 +86,20 @@ func (h *UserHandler) HandleGetProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create response without sensitive fields
-	type ProfileResponse struct {
-		ID                int        `json:"id"`
-		Username          string     `json:"username"`
-		Email             string     `json:"email"`
-		ProfilePictureURL *string    `json:"profile_picture_url,omitempty"`
-		Status            string     `json:"status"`
-		CreatedAt         time.Time  `json:"created_at"`
-		LastLogin         *time.Time `json:"last_login,omitempty"`
-	}
-
-	profile := ProfileResponse{
-		ID:                user.ID,
-		Username:          user.Username,
-		Email:             user.Email,
-		ProfilePictureURL: user.ProfilePictureURL,
-		Status:            user.Status,
-		CreatedAt:         user.CreatedAt,
-		LastLogin:         user.LastLogin,
+	profile, err := h.newProfileResponse(user)
+	if err != nil {
+		log.Printf("Failed to get user profile: %v", err)
+		http.Error(w, "Failed to get user profile", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(profile)
 }
 
-// HandleUpdateProfile updates the current user's profile
+// HandleUpdateProfile updates the current user's profile. A new email address
+// only replaces the current one once it is verified through the link emailed
+// to it.
 func (h *UserHandler) HandleUpdateProfile(w http.ResponseWriter, r *http.Request) {
 	// Get user ID from context (set by auth middleware)
 	userID, ok := r.Context().Value("user_id").(int)
@@ -76,8 +110,9 @@ func (h *UserHandler) HandleUpdateProfile(w http.ResponseWriter, r *http.Request
 
 	// Parse request body
 	type UpdateProfileRequest struct {
-		Email  string `json:"email,omitempty"`
-		Status string `json:"status,omitempty"`
+		Email        string `json:"email,omitempty"`
+		Status       string `json:"status,omitempty"`
+		HideLastSeen *bool  `json:"hide_last_seen,omitempty"`
 	}
 
 	var req UpdateProfileRequest
@@ -86,11 +121,27 @@ func (h *UserHandler) HandleUpdateProfile(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Get the current user to compare the email with
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user profile: %v", err)
+		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		return
+	}
+
 	// Validate inputs
 	updates := make(map[string]interface{})
 
+	newEmail := ""
 	if req.Email != "" {
-		updates["email"] = req.Email
+		email, ok := normalizeEmail(req.Email)
+		if !ok {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+		if email != user.Email {
+			newEmail = email
+		}
 	}
 
 	if req.Status != "" {
@@ -110,53 +161,67 @@ func (h *UserHandler) HandleUpdateProfile(w http.ResponseWriter, r *http.Request
 		updates["status"] = req.Status
 	}
 
+	if req.HideLastSeen != nil {
+		updates["hide_last_seen"] = *req.HideLastSeen
+	}
+
 	// If no updates, return error
-	if len(updates) == 0 {
+	if len(updates) == 0 && req.Email == "" {
 		http.Error(w, "No valid fields to update", http.StatusBadRequest)
 		return
 	}
 
-	// Update profile in database
-	if err := h.DB.UpdateUserProfile(userID, updates); err != nil {
-		log.Printf("Failed to update user profile: %v", err)
-
-		// Check for specific errors
-		if err.Error() == "email is already in use" {
+	// Check the new email before changing anything
+	if newEmail != "" {
+		inUse, err := h.DB.IsEmailInUse(newEmail, userID)
+		if err != nil {
+			log.Printf("Failed to check email: %v", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+		if inUse {
 			http.Error(w, "Email address is already in use", http.StatusConflict)
 			return
 		}
 
-		http.Error(w, "Failed to update profile", http.StatusInternalServerError)
-		return
+		if ok, retryAfter := h.Verifier.Allow(userID); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "Too many verification emails requested, try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	// Update profile in database
+	if len(updates) > 0 {
+		if err := h.DB.UpdateUserProfile(userID, updates); err != nil {
+			log.Printf("Failed to update user profile: %v", err)
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The new email is applied when the user follows the emailed link
+	if newEmail != "" {
+		if err := h.Verifier.Send(user, newEmail); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", userID, err)
+			http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+			return
+		}
 	}
 
 	// Get updated user profile
-	user, err := h.DB.GetUserByID(userID)
+	user, err = h.DB.GetUserByID(userID)
 	if err != nil {
 		log.Printf("Failed to get updated user profile: %v", err)
 		http.Error(w, "Profile updated but failed to retrieve", http.StatusInternalServerError)
 		return
 	}
 
-	// Create response without sensitive fields
-	type ProfileResponse struct {
-		ID                int        `json:"id"`
-		Username          string     `json:"username"`
-		Email             string     `json:"email"`
-		ProfilePictureURL *string    `json:"profile_picture_url,omitempty"`
-		Status            string     `json:"status"`
-		CreatedAt         time.Time  `json:"created_at"`
-		LastLogin         *time.Time `json:"last_login,omitempty"`
-	}
-
-	profile := ProfileResponse{
-		ID:                user.ID,
-		Username:          user.Username,
-		Email:             user.Email,
-		ProfilePictureURL: user.ProfilePictureURL,
-		Status:            user.Status,
-		CreatedAt:         user.CreatedAt,
-		LastLogin:         user.LastLogin,
+	profile, err := h.newProfileResponse(user)
+	if err != nil {
+		log.Printf("Failed to get updated user profile: %v", err)
+		http.Error(w, "Profile updated but failed to retrieve", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -196,6 +261,8 @@ func (h *UserHandler) HandleGetUsersByIDs(w http.ResponseWriter, r *http.Request
 			return
 		}
 
+		h.applyPresence(users)
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(users)
 		return
@@ -229,6 +296,8 @@ func (h *UserHandler) HandleGetUsersByIDs(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	h.applyPresence(users)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
 }
@@ -273,6 +342,11 @@ func (h *UserHandler) HandleSearchUsers(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Replace stored statuses with live presence
+	for i := range users {
+		h.Presence.DecorateUser(&users[i])
+	}
+
 	// Return results
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -281,3 +355,11 @@ func (h *UserHandler) HandleSearchUsers(w http.ResponseWriter, r *http.Request)
 		"query": searchTerm,
 	})
 }
+
+// applyPresence replaces the stored statuses with live presence
+func (h *UserHandler) applyPresence(users map[int]models.UserBasic) {
+	for id, user := range users {
+		h.Presence.DecorateUser(&user)
+		users[id] = user
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"OurChat/internal/db"
+	"OurChat/internal/mail"
+	"OurChat/internal/models"
+	"OurChat/internal/ratelimit"
+)
+
+// How long emailed verification links stay valid unless configured otherwise
+const defaultEmailVerificationLifetime = 48 * time.Hour
+
+// Longest email address accepted, the limit of the SMTP path
+const maxEmailLength = 254
+
+// Verification emails a user can ask for every verificationWindow, on top of
+// the one sent when registering
+const (
+	verificationLimit  = 3
+	verificationWindow = time.Hour
+)
+
+// EmailVerifier emails the links users follow to verify an address, either
+// the one they registered with or a new one they want to change to
+type EmailVerifier struct {
+	DB     *db.DB
+	Mailer mail.Mailer
+	// Address of the web app, for links in emails
+	PublicURL string
+	// How long verification links stay valid
+	Lifetime time.Duration
+
+	limiter *ratelimit.Limiter
+}
+
+// NewEmailVerifier creates a new email verifier
+func NewEmailVerifier(db *db.DB, mailer mail.Mailer) *EmailVerifier {
+	return &EmailVerifier{
+		DB:        db,
+		Mailer:    mailer,
+		PublicURL: "http://localhost:8080",
+		Lifetime:  defaultEmailVerificationLifetime,
+		limiter:   ratelimit.NewLimiter(verificationLimit, verificationWindow),
+	}
+}
+
+// Send emails a verification link for email to the user. Any link sent to the
+// user before stops working. When email isn't the user's current address, it
+// replaces that address once verified.
+func (v *EmailVerifier) Send(user *models.User, email string) error {
+	token, err := v.DB.CreateEmailVerification(user.ID, email, v.Lifetime)
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(v.PublicURL, "/") + "/verifyEmail?token=" + url.QueryEscape(token)
+
+	msg := &mail.Message{
+		To:      email,
+		Subject: "Verify your OurChat email address",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Welcome to OurChat! Open this link to verify your email address:\n\n"+
+			"%s\n\n"+
+			"The link expires in %s. If you didn't create an account, you can ignore this email.\n",
+			user.Username, link, formatLifetime(v.Lifetime)),
+	}
+	if email != user.Email {
+		msg.Subject = "Confirm your new OurChat email address"
+		msg.Body = fmt.Sprintf("Hi %s,\n\n"+
+			"You asked to change the email address of your OurChat account to this one. Open this link to confirm:\n\n"+
+			"%s\n\n"+
+			"The link expires in %s. Until then your account keeps using %s. If you didn't ask for this, you can ignore this email.\n",
+			user.Username, link, formatLifetime(v.Lifetime), user.Email)
+	}
+
+	if err := v.Mailer.Send(msg); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	return nil
+}
+
+// Allow reports whether the user may ask for another verification email, and
+// if not, how long until they may
+func (v *EmailVerifier) Allow(userID int) (bool, time.Duration) {
+	return v.limiter.Allow(strconv.Itoa(userID))
+}
+
+// normalizeEmail trims an email address and checks that it is a bare address
+// like "alice@example.com", without a display name
+func normalizeEmail(email string) (string, bool) {
+	email = strings.TrimSpace(email)
+	if len(email) > maxEmailLength {
+		return "", false
+	}
+
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email {
+		return "", false
+	}
+
+	return email, true
+}
+
+// VerifyEmailRequest represents the email verification request body
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// HandleVerifyEmail verifies the address a verification link was sent to.
+// For an email change, that address becomes the user's email.
+func (h *AuthHandler) HandleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	// Parse request body
+	var req VerifyEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	_, email, err := h.DB.VerifyEmail(req.Token)
+	if err != nil {
+		log.Printf("Email verification failed: %v", err)
+
+		if errors.Is(err, db.ErrEmailInUse) {
+			http.Error(w, "Email address is already in use", http.StatusConflict)
+			return
+		}
+
+		http.Error(w, "Invalid or expired verification token", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Email verified successfully",
+		"email":   email,
+	})
+}
+
+// HandleResendVerification sends the current user a new verification link,
+// for their pending email change if they have one, else for their address
+func (h *AuthHandler) HandleResendVerification(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from context (set by auth middleware)
+	userID, ok := r.Context().Value("user_id").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.DB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", userID, err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	email, err := h.DB.GetPendingEmail(userID)
+	if err != nil {
+		log.Printf("Failed to get pending email: %v", err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	if email == "" {
+		if user.EmailVerifiedAt != nil {
+			http.Error(w, "Email address is already verified", http.StatusConflict)
+			return
+		}
+		email = user.Email
+	}
+
+	if ok, retryAfter := h.Verifier.Allow(userID); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		http.Error(w, "Too many verification emails requested, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	if err := h.Verifier.Send(user, email); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		http.Error(w, "Failed to send verification email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Verification email sent to " + email,
+	})
+}
@@ -2,23 +2,29 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 
 	"OurChat/internal/api/utils"
 	"OurChat/internal/db"
+	"OurChat/internal/revocation"
+
+	"github.com/gorilla/websocket"
 )
 
 // AuthMiddleware is a middleware for JWT authentication
 type AuthMiddleware struct {
-	DB *db.DB
+	DB          *db.DB
+	Revocations *revocation.Store
 }
 
 // NewAuthMiddleware creates a new authentication middleware
-func NewAuthMiddleware(db *db.DB) *AuthMiddleware {
+func NewAuthMiddleware(db *db.DB, revocations *revocation.Store) *AuthMiddleware {
 	return &AuthMiddleware{
-		DB: db,
+		DB:          db,
+		Revocations: revocations,
 	}
 }
 
@@ -26,21 +32,12 @@ func NewAuthMiddleware(db *db.DB) *AuthMiddleware {
 func (m *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Authorization header required", http.StatusUnauthorized)
-			return
-		}
-
-		// Check if the header has the Bearer prefix
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Authorization header must be in format: Bearer {token}", http.StatusUnauthorized)
+		tokenString, err := tokenFromRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 
-		tokenString := parts[1]
-
 		// Parse and validate token
 		claims, err := utils.ValidateJWT(tokenString, m.DB)
 		if err != nil {
@@ -56,14 +53,61 @@ func (m *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Tokens issued before sessions existed carry no token or session ID
+		tokenID, _ := claims["jti"].(string)
+		sessionID, _ := claims["session_id"].(float64)
+
+		// Reject tokens revoked by logging out
+		if m.Revocations.IsRevoked(tokenID, int(sessionID)) {
+			log.Printf("Revoked token used for user %d", int(userID))
+			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			return
+		}
+
 		// Create a new context with the user ID
 		ctx := context.WithValue(r.Context(), "user_id", int(userID))
 
+		// Keep the token and session IDs so they can be revoked on logout
+		if tokenID != "" {
+			expiresAt, _ := claims.GetExpirationTime()
+			ctx = context.WithValue(ctx, "token_id", tokenID)
+			ctx = context.WithValue(ctx, "token_expires_at", expiresAt.Time)
+		}
+		if sessionID != 0 {
+			ctx = context.WithValue(ctx, "session_id", int(sessionID))
+		}
+
 		// Call the next handler with the updated context
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// tokenFromRequest extracts the bearer token from the Authorization header.
+// Browsers can't set headers on WebSocket upgrades or EventSource requests,
+// so those may pass the token in the "token" query parameter instead.
+func tokenFromRequest(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		if token := r.URL.Query().Get("token"); token != "" && isStreamingRequest(r) {
+			return token, nil
+		}
+		return "", errors.New("Authorization header required")
+	}
+
+	// Check if the header has the Bearer prefix
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", errors.New("Authorization header must be in format: Bearer {token}")
+	}
+
+	return parts[1], nil
+}
+
+// isStreamingRequest reports whether the request opens a WebSocket or SSE stream
+func isStreamingRequest(r *http.Request) bool {
+	return websocket.IsWebSocketUpgrade(r) || strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
 // RequireAuth is a middleware wrapper for routes that require authentication
 func (m *AuthMiddleware) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
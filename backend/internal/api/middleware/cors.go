@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORSMiddleware lets browsers on the configured origins call the API
+type CORSMiddleware struct {
+	AllowedOrigins map[string]bool
+	AllowAll       bool
+}
+
+// Methods and request headers the API accepts from other origins
+const (
+	corsAllowedMethods = "GET, POST, PUT, DELETE, OPTIONS"
+	corsAllowedHeaders = "Authorization, Content-Type, Last-Event-ID"
+	corsMaxAge         = "86400"
+)
+
+// NewCORSMiddleware creates a new CORS middleware. "*" allows every origin.
+func NewCORSMiddleware(origins []string) *CORSMiddleware {
+	m := &CORSMiddleware{
+		AllowedOrigins: make(map[string]bool),
+	}
+
+	for _, origin := range origins {
+		if origin == "*" {
+			m.AllowAll = true
+		}
+		m.AllowedOrigins[strings.ToLower(origin)] = true
+	}
+
+	return m
+}
+
+// Middleware adds the CORS headers for allowed origins and answers preflight
+// requests. It has to wrap the whole router, since preflight OPTIONS requests
+// don't match any route.
+func (m *CORSMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || (!m.AllowAll && !m.AllowedOrigins[strings.ToLower(origin)]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The response depends on the origin, so caches must keep them apart
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		// Answer preflight requests without passing them on
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
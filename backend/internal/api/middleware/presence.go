@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+
+	"OurChat/internal/presence"
+)
+
+// PresenceMiddleware records every authenticated request as user activity
+type PresenceMiddleware struct {
+	Presence *presence.Service
+}
+
+// NewPresenceMiddleware creates a new presence middleware
+func NewPresenceMiddleware(presence *presence.Service) *PresenceMiddleware {
+	return &PresenceMiddleware{
+		Presence: presence,
+	}
+}
+
+// Middleware marks the authenticated user as active. It must run after the
+// authentication middleware.
+func (m *PresenceMiddleware) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID, ok := r.Context().Value("user_id").(int); ok {
+			m.Presence.Touch(userID)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
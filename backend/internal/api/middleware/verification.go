@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"OurChat/internal/db"
+)
+
+// VerificationMiddleware keeps users who haven't verified their email address
+// from taking restricted actions
+type VerificationMiddleware struct {
+	DB *db.DB
+	// Actions that need a verified email, see the config.Action* constants
+	Restricted map[string]bool
+}
+
+// NewVerificationMiddleware creates a new verification middleware restricting
+// the given actions
+func NewVerificationMiddleware(db *db.DB, restricted []string) *VerificationMiddleware {
+	m := &VerificationMiddleware{
+		DB:         db,
+		Restricted: make(map[string]bool, len(restricted)),
+	}
+	for _, action := range restricted {
+		m.Restricted[action] = true
+	}
+
+	return m
+}
+
+// Require wraps the handler of a route performing action so it is only
+// reachable with a verified email, if the action is restricted. It must run
+// after the authentication middleware.
+func (m *VerificationMiddleware) Require(action string, next http.HandlerFunc) http.HandlerFunc {
+	if !m.Restricted[action] {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := r.Context().Value("user_id").(int)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		user, err := m.DB.GetUserByID(userID)
+		if err != nil {
+			log.Printf("Failed to get user %d: %v", userID, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if user.EmailVerifiedAt == nil {
+			http.Error(w, "Verify your email address to do this", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
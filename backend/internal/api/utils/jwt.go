@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"time"
@@ -11,16 +13,39 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// JWT expiration time (24 hours)
-const JWTExpiration = time.Hour * 24
+// Default JWT expiration time (15 minutes). Clients get new tokens with
+// their session's refresh token.
+const JWTExpiration = time.Minute * 15
+
+// Default time a session survives without its refresh token being used (30 days)
+const RefreshTokenExpiration = time.Hour * 24 * 30
+
+// generateTokenID creates a random token ID (jti) so a single token can be
+// revoked
+func generateTokenID() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// GenerateJWT generates a JWT token for a user's session that expires after
+// lifetime
+func GenerateJWT(user *models.User, sessionID int, lifetime time.Duration) (string, error) {
+	jti, err := generateTokenID()
+	if err != nil {
+		return "", err
+	}
 
-// GenerateJWT generates a JWT token for a user
-func GenerateJWT(user *models.User) (string, error) {
 	// Create token
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(JWTExpiration).Unix(),
-		"iat":     time.Now().Unix(),
+		"jti":        jti,
+		"user_id":    user.ID,
+		"session_id": sessionID,
+		"exp":        time.Now().Add(lifetime).Unix(),
+		"iat":        time.Now().Unix(),
 	})
 
 	// Sign token with user's JWT key
@@ -32,7 +57,8 @@ func GenerateJWT(user *models.User) (string, error) {
 	return tokenString, nil
 }
 
-// ValidateJWT validates a JWT token and returns the claims if valid
+// ValidateJWT validates an access token and returns the claims if valid.
+// Revocation of single tokens is checked by the caller.
 func ValidateJWT(tokenString string, db *db.DB) (jwt.MapClaims, error) {
 	// Parse the token
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -47,6 +73,12 @@ func ValidateJWT(tokenString string, db *db.DB) (jwt.MapClaims, error) {
 			return nil, fmt.Errorf("invalid token claims")
 		}
 
+		// Password reset and 2FA challenge tokens are signed with the same
+		// key, but aren't access tokens
+		if _, ok := claims["purpose"]; ok {
+			return nil, fmt.Errorf("not an access token")
+		}
+
 		// Extract user ID from claims
 		userIDFloat, ok := claims["user_id"].(float64)
 		if !ok {
@@ -60,6 +92,10 @@ func ValidateJWT(tokenString string, db *db.DB) (jwt.MapClaims, error) {
 			return nil, fmt.Errorf("user not found: %w", err)
 		}
 
+		if user.DisabledAt != nil {
+			return nil, fmt.Errorf("user %d is disabled", userID)
+		}
+
 		// Return the user's JWT key for validation
 		return []byte(user.JWTKey), nil
 	})
@@ -80,17 +116,18 @@ func ValidateJWT(tokenString string, db *db.DB) (jwt.MapClaims, error) {
 	return claims, nil
 }
 
-// Password reset token expiration (30 minutes)
+// Default password reset token expiration (30 minutes)
 const PasswordResetExpiration = time.Minute * 30
 
-// GeneratePasswordResetToken creates a JWT token for password reset
-func GeneratePasswordResetToken(user *models.User) (string, error) {
+// GeneratePasswordResetToken creates a JWT token for password reset that
+// expires after lifetime
+func GeneratePasswordResetToken(user *models.User, lifetime time.Duration) (string, error) {
 	// Create the token with claims
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"email":   user.Email,
 		"user_id": user.ID,
 		"purpose": "password_reset", // Explicit purpose for additional security
-		"exp":     time.Now().Add(PasswordResetExpiration).Unix(),
+		"exp":     time.Now().Add(lifetime).Unix(),
 		"iat":     time.Now().Unix(),
 	})
 
@@ -162,3 +199,82 @@ func ValidatePasswordResetToken(tokenString string, db *db.DB) (int, error) {
 
 	return int(userIDFloat), nil
 }
+
+// Default lifetime of the challenge token returned by logging in with 2FA on
+// (5 minutes)
+const TwoFactorChallengeExpiration = time.Minute * 5
+
+// GenerateTwoFactorChallengeToken creates the token a user with 2FA gets for
+// a correct password. It is exchanged for a session together with a code,
+// and carries the device name from the login request.
+func GenerateTwoFactorChallengeToken(user *models.User, deviceName string, lifetime time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":     user.ID,
+		"device_name": deviceName,
+		"purpose":     "mfa_challenge", // Never accepted as an access token
+		"exp":         time.Now().Add(lifetime).Unix(),
+		"iat":         time.Now().Unix(),
+	})
+
+	// Sign token with user's current JWT key
+	tokenString, err := token.SignedString([]byte(user.JWTKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return tokenString, nil
+}
+
+// ValidateTwoFactorChallengeToken validates a 2FA challenge token and returns
+// the user ID and device name it was issued for
+func ValidateTwoFactorChallengeToken(tokenString string, db *db.DB) (int, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		// Validate the algorithm
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		claims, ok := token.Claims.(jwt.MapClaims)
+		if !ok {
+			return nil, fmt.Errorf("invalid token claims")
+		}
+
+		if purpose, _ := claims["purpose"].(string); purpose != "mfa_challenge" {
+			return nil, fmt.Errorf("invalid token purpose")
+		}
+
+		userIDFloat, ok := claims["user_id"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("invalid user_id in token")
+		}
+
+		// Get the user from the database to retrieve their JWT key
+		user, err := db.GetUserByID(int(userIDFloat))
+		if err != nil {
+			return nil, fmt.Errorf("user not found: %w", err)
+		}
+
+		return []byte(user.JWTKey), nil
+	})
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	if !token.Valid {
+		return 0, "", errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, "", errors.New("invalid token claims")
+	}
+
+	userIDFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, "", errors.New("invalid user_id in token")
+	}
+	deviceName, _ := claims["device_name"].(string)
+
+	return int(userIDFloat), deviceName, nil
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"OurChat/internal/db"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateJWT(t *testing.T) {
+	database, err := db.NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	defer database.Close()
+
+	if err := database.CreateUser("alice", "alice@example.com", "password"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	user, err := database.GetUserByUsername("alice")
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+
+	newToken := func(generate func() (string, error)) string {
+		t.Helper()
+		token, err := generate()
+		if err != nil {
+			t.Fatalf("failed to generate token: %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{
+			"access token",
+			newToken(func() (string, error) { return GenerateJWT(user, 1, time.Minute) }),
+			true,
+		},
+		{
+			"expired access token",
+			newToken(func() (string, error) { return GenerateJWT(user, 1, -time.Minute) }),
+			false,
+		},
+		{
+			"password reset token",
+			newToken(func() (string, error) { return GeneratePasswordResetToken(user, time.Minute) }),
+			false,
+		},
+		{
+			"2FA challenge token",
+			newToken(func() (string, error) { return GenerateTwoFactorChallengeToken(user, "laptop", time.Minute) }),
+			false,
+		},
+		{
+			"wrong key",
+			newToken(func() (string, error) {
+				return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+					"user_id": user.ID,
+					"exp":     time.Now().Add(time.Minute).Unix(),
+				}).SignedString([]byte("not the user's key"))
+			}),
+			false,
+		},
+		{"malformed token", "not-a-jwt", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ValidateJWT(tt.token, database)
+			if (err == nil) != tt.wantOK {
+				t.Fatalf("ValidateJWT() error = %v, want ok %v", err, tt.wantOK)
+			}
+			if tt.wantOK && int(claims["user_id"].(float64)) != user.ID {
+				t.Errorf("user_id claim = %v, want %d", claims["user_id"], user.ID)
+			}
+		})
+	}
+}
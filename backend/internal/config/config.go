@@ -0,0 +1,499 @@
+// Package config loads the server configuration. Settings start from built-in
+// defaults, are overridden by an optional YAML file and then by OURCHAT_*
+// environment variables.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFileEnv names the environment variable holding the config file path
+const ConfigFileEnv = "OURCHAT_CONFIG"
+
+// Config is the complete server configuration
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Media    MediaConfig    `yaml:"media"`
+	Auth     AuthConfig     `yaml:"auth"`
+	CORS     CORSConfig     `yaml:"cors"`
+	Presence PresenceConfig `yaml:"presence"`
+	Messages MessagesConfig `yaml:"messages"`
+	Frontend FrontendConfig `yaml:"frontend"`
+	Mail     MailConfig     `yaml:"mail"`
+
+	// File the configuration was read from, empty if none
+	source string
+}
+
+// ServerConfig configures the HTTP listener
+type ServerConfig struct {
+	Host string `yaml:"host"` // Empty listens on every interface
+	Port int    `yaml:"port"`
+	// Address users reach the web app at, used for links in emails
+	PublicURL string `yaml:"public_url"`
+
+	// Limits for a single request; 0 disables a timeout. Both the read and
+	// write timeouts have to cover the slowest upload. The write timeout
+	// doesn't apply to realtime streams.
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout"`
+	MaxHeaderBytes ByteSize      `yaml:"max_header_bytes"`
+
+	// Time given to in-flight requests to finish when the server stops
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Address returns the address to listen on
+func (s ServerConfig) Address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// DatabaseConfig configures the SQLite database
+type DatabaseConfig struct {
+	Path string `yaml:"path"`
+}
+
+// MediaConfig configures uploaded files
+type MediaConfig struct {
+	UploadDir             string   `yaml:"upload_dir"`
+	MaxUploadSize         ByteSize `yaml:"max_upload_size"`
+	MaxProfilePictureSize ByteSize `yaml:"max_profile_picture_size"` // Also used for chat avatars
+}
+
+// AuthConfig configures authentication tokens
+type AuthConfig struct {
+	TokenLifetime time.Duration `yaml:"token_lifetime"` // Access tokens
+	// Sessions end when their refresh token goes unused this long
+	RefreshTokenLifetime      time.Duration `yaml:"refresh_token_lifetime"`
+	PasswordResetLifetime     time.Duration `yaml:"password_reset_lifetime"`
+	EmailVerificationLifetime time.Duration `yaml:"email_verification_lifetime"`
+	// Time users with 2FA have to enter a code after their password
+	TwoFactorChallengeLifetime time.Duration `yaml:"two_factor_challenge_lifetime"`
+	// Actions users can't take until they verify their email address
+	UnverifiedRestrictions []string `yaml:"unverified_restrictions"`
+}
+
+// Actions that can be restricted to users with a verified email address
+const (
+	ActionCreateChat  = "create_chat"  // Create chats
+	ActionJoinChat    = "join_chat"    // Join chats through invite links
+	ActionSendMessage = "send_message" // Send messages
+	ActionUploadMedia = "upload_media" // Upload files, pictures and avatars
+)
+
+// restrictableActions lists the valid auth.unverified_restrictions entries
+var restrictableActions = []string{ActionCreateChat, ActionJoinChat, ActionSendMessage, ActionUploadMedia}
+
+// CORSConfig configures cross-origin requests to the API
+type CORSConfig struct {
+	// Origins allowed to call the API, or "*" for any. Empty disables CORS
+	// headers, which is right when the API is served from the same origin.
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// PresenceConfig configures typing indicators and activity tracking
+type PresenceConfig struct {
+	TypingTTL    time.Duration `yaml:"typing_ttl"`
+	AwayAfter    time.Duration `yaml:"away_after"`
+	OfflineAfter time.Duration `yaml:"offline_after"`
+}
+
+// MessagesConfig configures messaging rules
+type MessagesConfig struct {
+	EditWindow time.Duration `yaml:"edit_window"`
+}
+
+// FrontendConfig configures serving the web app
+type FrontendConfig struct {
+	// Directory with a frontend build to serve instead of the one embedded
+	// with the embed_frontend build tag. Empty serves the embedded build, if
+	// any.
+	Dir string `yaml:"dir"`
+}
+
+// Ways of delivering email
+const (
+	MailDriverLog  = "log"  // Write emails to the server log
+	MailDriverFile = "file" // Write emails as .eml files to a directory
+	MailDriverSMTP = "smtp" // Send emails through an SMTP server
+)
+
+// MailConfig configures outgoing email
+type MailConfig struct {
+	Driver string     `yaml:"driver"`
+	From   string     `yaml:"from"`
+	Dir    string     `yaml:"dir"` // Used by the file driver
+	SMTP   SMTPConfig `yaml:"smtp"`
+}
+
+// SMTPConfig configures the SMTP server used by the smtp mail driver. The
+// connection is upgraded with STARTTLS when the server supports it.
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"` // Empty skips authentication
+	Password string `yaml:"password"`
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:            8080,
+			PublicURL:       "http://localhost:8080",
+			ReadTimeout:     5 * time.Minute,
+			WriteTimeout:    5 * time.Minute,
+			IdleTimeout:     2 * time.Minute,
+			MaxHeaderBytes:  64 * KB,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Path: "./data/ourchat.db",
+		},
+		Media: MediaConfig{
+			UploadDir:             "./uploads",
+			MaxUploadSize:         50 * MB,
+			MaxProfilePictureSize: 5 * MB,
+		},
+		Auth: AuthConfig{
+			TokenLifetime:              15 * time.Minute,
+			RefreshTokenLifetime:       30 * 24 * time.Hour,
+			PasswordResetLifetime:      30 * time.Minute,
+			EmailVerificationLifetime:  48 * time.Hour,
+			TwoFactorChallengeLifetime: 5 * time.Minute,
+			UnverifiedRestrictions:     []string{ActionCreateChat, ActionUploadMedia},
+		},
+		Presence: PresenceConfig{
+			TypingTTL:    6 * time.Second,
+			AwayAfter:    5 * time.Minute,
+			OfflineAfter: 15 * time.Minute,
+		},
+		Messages: MessagesConfig{
+			EditWindow: 48 * time.Hour,
+		},
+		Mail: MailConfig{
+			Driver: MailDriverLog,
+			From:   "OurChat <no-reply@localhost>",
+			Dir:    "./data/mail",
+			SMTP: SMTPConfig{
+				Port: 587,
+			},
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, the YAML file at path (if
+// not empty) and the environment, and validates the result
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// loadFile overrides the configuration with the settings in a YAML file.
+// Unknown keys are rejected so typos don't go unnoticed.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	c.source = path
+	return nil
+}
+
+// loadEnv overrides the configuration with the OURCHAT_* environment variables
+// that are set
+func (c *Config) loadEnv() error {
+	vars := []struct {
+		name string
+		set  func(string) error
+	}{
+		{"OURCHAT_SERVER_HOST", setString(&c.Server.Host)},
+		{"OURCHAT_SERVER_PORT", setInt(&c.Server.Port)},
+		{"OURCHAT_PUBLIC_URL", setString(&c.Server.PublicURL)},
+		{"OURCHAT_READ_TIMEOUT", setDuration(&c.Server.ReadTimeout)},
+		{"OURCHAT_WRITE_TIMEOUT", setDuration(&c.Server.WriteTimeout)},
+		{"OURCHAT_IDLE_TIMEOUT", setDuration(&c.Server.IdleTimeout)},
+		{"OURCHAT_MAX_HEADER_BYTES", setByteSize(&c.Server.MaxHeaderBytes)},
+		{"OURCHAT_SHUTDOWN_TIMEOUT", setDuration(&c.Server.ShutdownTimeout)},
+		{"OURCHAT_DATABASE_PATH", setString(&c.Database.Path)},
+		{"OURCHAT_UPLOAD_DIR", setString(&c.Media.UploadDir)},
+		{"OURCHAT_MAX_UPLOAD_SIZE", setByteSize(&c.Media.MaxUploadSize)},
+		{"OURCHAT_MAX_PROFILE_PICTURE_SIZE", setByteSize(&c.Media.MaxProfilePictureSize)},
+		{"OURCHAT_TOKEN_LIFETIME", setDuration(&c.Auth.TokenLifetime)},
+		{"OURCHAT_REFRESH_TOKEN_LIFETIME", setDuration(&c.Auth.RefreshTokenLifetime)},
+		{"OURCHAT_PASSWORD_RESET_LIFETIME", setDuration(&c.Auth.PasswordResetLifetime)},
+		{"OURCHAT_EMAIL_VERIFICATION_LIFETIME", setDuration(&c.Auth.EmailVerificationLifetime)},
+		{"OURCHAT_UNVERIFIED_RESTRICTIONS", setList(&c.Auth.UnverifiedRestrictions)},
+		{"OURCHAT_TWO_FACTOR_CHALLENGE_LIFETIME", setDuration(&c.Auth.TwoFactorChallengeLifetime)},
+		{"OURCHAT_CORS_ALLOWED_ORIGINS", setList(&c.CORS.AllowedOrigins)},
+		{"OURCHAT_TYPING_TTL", setDuration(&c.Presence.TypingTTL)},
+		{"OURCHAT_AWAY_AFTER", setDuration(&c.Presence.AwayAfter)},
+		{"OURCHAT_OFFLINE_AFTER", setDuration(&c.Presence.OfflineAfter)},
+		{"OURCHAT_EDIT_WINDOW", setDuration(&c.Messages.EditWindow)},
+		{"OURCHAT_FRONTEND_DIR", setString(&c.Frontend.Dir)},
+		{"OURCHAT_MAIL_DRIVER", setString(&c.Mail.Driver)},
+		{"OURCHAT_MAIL_FROM", setString(&c.Mail.From)},
+		{"OURCHAT_MAIL_DIR", setString(&c.Mail.Dir)},
+		{"OURCHAT_SMTP_HOST", setString(&c.Mail.SMTP.Host)},
+		{"OURCHAT_SMTP_PORT", setInt(&c.Mail.SMTP.Port)},
+		{"OURCHAT_SMTP_USERNAME", setString(&c.Mail.SMTP.Username)},
+		{"OURCHAT_SMTP_PASSWORD", setString(&c.Mail.SMTP.Password)},
+	}
+
+	for _, v := range vars {
+		value, ok := os.LookupEnv(v.name)
+		if !ok {
+			continue
+		}
+
+		if err := v.set(strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("invalid %s: %w", v.name, err)
+		}
+	}
+
+	return nil
+}
+
+func setString(dest *string) func(string) error {
+	return func(value string) error {
+		*dest = value
+		return nil
+	}
+}
+
+func setInt(dest *int) func(string) error {
+	return func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*dest = parsed
+		return nil
+	}
+}
+
+func setDuration(dest *time.Duration) func(string) error {
+	return func(value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*dest = parsed
+		return nil
+	}
+}
+
+func setByteSize(dest *ByteSize) func(string) error {
+	return func(value string) error {
+		parsed, err := ParseByteSize(value)
+		if err != nil {
+			return err
+		}
+		*dest = parsed
+		return nil
+	}
+}
+
+// setList parses a comma-separated list, ignoring empty entries
+func setList(dest *[]string) func(string) error {
+	return func(value string) error {
+		list := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*dest = list
+		return nil
+	}
+}
+
+// Validate checks that the configuration is usable, reporting every problem
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.ReadTimeout >= 0, "read timeout can't be negative")
+	check(c.Server.WriteTimeout >= 0, "write timeout can't be negative")
+	check(c.Server.IdleTimeout >= 0, "idle timeout can't be negative")
+	check(c.Server.MaxHeaderBytes >= KB, "max header bytes must be at least 1KB")
+	check(c.Server.ShutdownTimeout > 0, "shutdown timeout must be positive")
+	check(isValidPublicURL(c.Server.PublicURL), "public URL must be an absolute http or https URL, got %q", c.Server.PublicURL)
+	check(c.Database.Path != "", "database path is required")
+	check(c.Media.UploadDir != "", "upload directory is required")
+	check(c.Media.MaxUploadSize > 0, "max upload size must be positive")
+	check(c.Media.MaxProfilePictureSize > 0, "max profile picture size must be positive")
+	check(c.Auth.TokenLifetime > 0, "token lifetime must be positive")
+	check(c.Auth.RefreshTokenLifetime > c.Auth.TokenLifetime, "refresh token lifetime must be longer than token lifetime")
+	check(c.Auth.PasswordResetLifetime > 0, "password reset lifetime must be positive")
+	check(c.Auth.EmailVerificationLifetime > 0, "email verification lifetime must be positive")
+	check(c.Auth.TwoFactorChallengeLifetime > 0, "two-factor challenge lifetime must be positive")
+	for _, action := range c.Auth.UnverifiedRestrictions {
+		check(slices.Contains(restrictableActions, action), "unknown unverified restriction %q, expected one of %s",
+			action, strings.Join(restrictableActions, ", "))
+	}
+	check(c.Presence.TypingTTL > 0, "typing TTL must be positive")
+	check(c.Presence.AwayAfter > 0, "away after must be positive")
+	check(c.Presence.OfflineAfter > c.Presence.AwayAfter, "offline after must be longer than away after")
+	check(c.Messages.EditWindow > 0, "edit window must be positive")
+
+	if c.Frontend.Dir != "" {
+		info, err := os.Stat(c.Frontend.Dir)
+		check(err == nil && info.IsDir(), "frontend directory %s doesn't exist", c.Frontend.Dir)
+	}
+
+	_, err := mail.ParseAddress(c.Mail.From)
+	check(err == nil, "invalid mail sender address %q", c.Mail.From)
+	switch c.Mail.Driver {
+	case MailDriverLog:
+	case MailDriverFile:
+		check(c.Mail.Dir != "", "mail directory is required for the file mail driver")
+	case MailDriverSMTP:
+		check(c.Mail.SMTP.Host != "", "SMTP host is required for the smtp mail driver")
+		check(c.Mail.SMTP.Port > 0 && c.Mail.SMTP.Port <= 65535, "SMTP port must be between 1 and 65535, got %d", c.Mail.SMTP.Port)
+	default:
+		check(false, "unknown mail driver %q, expected log, file or smtp", c.Mail.Driver)
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(isValidOrigin(origin), "invalid CORS origin %q, expected \"*\" or scheme://host[:port]", origin)
+	}
+
+	return errors.Join(errs...)
+}
+
+// isValidOrigin checks that an allowed origin is "*" or a bare origin as sent
+// by browsers in the Origin header
+func isValidOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// isValidPublicURL checks that the public URL can prefix links to the app
+func isValidPublicURL(publicURL string) bool {
+	u, err := url.Parse(publicURL)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.RawQuery == "" && u.Fragment == "" && u.User == nil
+}
+
+// String describes the effective configuration, one setting per line
+func (c *Config) String() string {
+	source := "defaults and environment"
+	if c.source != "" {
+		source = c.source + ", defaults and environment"
+	}
+
+	origins := "none (same origin only)"
+	if len(c.CORS.AllowedOrigins) > 0 {
+		origins = strings.Join(c.CORS.AllowedOrigins, ", ")
+	}
+
+	frontend := c.Frontend.Dir
+	if frontend == "" {
+		frontend = "none (embedded build, if any)"
+	}
+
+	restrictions := "none"
+	if len(c.Auth.UnverifiedRestrictions) > 0 {
+		restrictions = strings.Join(c.Auth.UnverifiedRestrictions, ", ")
+	}
+
+	mailTransport := c.Mail.Driver
+	switch c.Mail.Driver {
+	case MailDriverFile:
+		mailTransport += " (" + c.Mail.Dir + ")"
+	case MailDriverSMTP:
+		mailTransport += " (" + net.JoinHostPort(c.Mail.SMTP.Host, strconv.Itoa(c.Mail.SMTP.Port))
+		if c.Mail.SMTP.Username != "" {
+			// Never print the password
+			mailTransport += " as " + c.Mail.SMTP.Username
+		}
+		mailTransport += ")"
+	}
+
+	lines := []string{
+		"source: " + source,
+		"server.address: " + c.Server.Address(),
+		"server.public_url: " + c.Server.PublicURL,
+		"server.read_timeout: " + c.Server.ReadTimeout.String(),
+		"server.write_timeout: " + c.Server.WriteTimeout.String(),
+		"server.idle_timeout: " + c.Server.IdleTimeout.String(),
+		"server.max_header_bytes: " + c.Server.MaxHeaderBytes.String(),
+		"server.shutdown_timeout: " + c.Server.ShutdownTimeout.String(),
+		"database.path: " + c.Database.Path,
+		"media.upload_dir: " + c.Media.UploadDir,
+		"media.max_upload_size: " + c.Media.MaxUploadSize.String(),
+		"media.max_profile_picture_size: " + c.Media.MaxProfilePictureSize.String(),
+		"auth.token_lifetime: " + c.Auth.TokenLifetime.String(),
+		"auth.refresh_token_lifetime: " + c.Auth.RefreshTokenLifetime.String(),
+		"auth.password_reset_lifetime: " + c.Auth.PasswordResetLifetime.String(),
+		"auth.email_verification_lifetime: " + c.Auth.EmailVerificationLifetime.String(),
+		"auth.unverified_restrictions: " + restrictions,
+		"auth.two_factor_challenge_lifetime: " + c.Auth.TwoFactorChallengeLifetime.String(),
+		"cors.allowed_origins: " + origins,
+		"presence.typing_ttl: " + c.Presence.TypingTTL.String(),
+		"presence.away_after: " + c.Presence.AwayAfter.String(),
+		"presence.offline_after: " + c.Presence.OfflineAfter.String(),
+		"messages.edit_window: " + c.Messages.EditWindow.String(),
+		"frontend.dir: " + frontend,
+		"mail.driver: " + mailTransport,
+		"mail.from: " + c.Mail.From,
+	}
+
+	return strings.Join(lines, "\n")
+}
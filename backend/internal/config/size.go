@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ByteSize is a size in bytes that can be written with a unit, like "50MB"
+type ByteSize int64
+
+// Size units, in powers of 1024
+const (
+	B  ByteSize = 1
+	KB          = 1024 * B
+	MB          = 1024 * KB
+	GB          = 1024 * MB
+)
+
+var sizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	// Longer suffixes first so "MB" isn't read as "B"
+	{"GB", GB},
+	{"MB", MB},
+	{"KB", KB},
+	{"B", B},
+}
+
+// ParseByteSize parses a number of bytes with an optional unit (B, KB, MB or
+// GB, case-insensitive)
+func ParseByteSize(value string) (ByteSize, error) {
+	text := strings.ToUpper(strings.TrimSpace(value))
+
+	unit := B
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(text, u.suffix) {
+			text = strings.TrimSpace(strings.TrimSuffix(text, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+
+	number, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+
+	return ByteSize(number) * unit, nil
+}
+
+// UnmarshalYAML accepts plain byte counts and sizes with a unit
+func (s *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := ParseByteSize(node.Value)
+	if err != nil {
+		return err
+	}
+
+	*s = parsed
+	return nil
+}
+
+// String formats the size in the largest unit that divides it
+func (s ByteSize) String() string {
+	for _, u := range sizeUnits {
+		if s != 0 && s%u.size == 0 {
+			return fmt.Sprintf("%d%s", s/u.size, u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(s))
+}
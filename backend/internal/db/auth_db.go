@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"fmt"
+	"strings"
 	"time"
 
 	"OurChat/internal/models"
@@ -55,15 +56,17 @@ func (db *DB) GetUserForAuth(username string) (*models.User, error) {
 // GetUserByID retrieves a user by ID (useful for JWT middleware)
 func (db *DB) GetUserByID(userID int) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, password, jwt_key, profile_picture_url, created_at, last_login, status
+	query := `SELECT id, username, email, password, jwt_key, profile_picture_url, created_at, last_login, status,
+	                 last_seen_at, hide_last_seen, disabled_at, email_verified_at, totp_secret, totp_enabled_at
 	          FROM users WHERE id = ?`
 
-	var lastLogin sql.NullTime
-	var profilePictureURL sql.NullString
+	var lastLogin, lastSeenAt, disabledAt, emailVerifiedAt, totpEnabledAt sql.NullTime
+	var profilePictureURL, totpSecret sql.NullString
 
 	err := db.QueryRow(query, userID).Scan(
 		&user.ID, &user.Username, &user.Email, &user.Password,
 		&user.JWTKey, &profilePictureURL, &user.CreatedAt, &lastLogin, &user.Status,
+		&lastSeenAt, &user.HideLastSeen, &disabledAt, &emailVerifiedAt, &totpSecret, &totpEnabledAt,
 	)
 
 	if err != nil {
@@ -81,6 +84,23 @@ func (db *DB) GetUserByID(userID int) (*models.User, error) {
 		user.ProfilePictureURL = &profilePictureURL.String
 	}
 
+	if lastSeenAt.Valid {
+		user.LastSeenAt = &lastSeenAt.Time
+	}
+
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+
+	user.TOTPSecret = totpSecret.String
+	if totpEnabledAt.Valid {
+		user.TOTPEnabledAt = &totpEnabledAt.Time
+	}
+
 	return user, nil
 }
 
@@ -142,13 +162,15 @@ func (db *DB) GetUserJWTKey(userID int) (string, error) {
 	return jwtKey, nil
 }
 
-// RequestPasswordReset initiates a password reset for a user
+// RequestPasswordReset initiates a password reset for the user with an email,
+// compared case-insensitively
 func (db *DB) RequestPasswordReset(email string) (*models.User, error) {
 	// Check if the user exists
 	user := &models.User{}
-	query := `SELECT id, username, email, jwt_key FROM users WHERE email = ?`
+	query := `SELECT id, username, email, jwt_key, disabled_at FROM users WHERE email = ? COLLATE NOCASE`
 
-	err := db.QueryRow(query, email).Scan(&user.ID, &user.Username, &user.Email, &user.JWTKey)
+	var disabledAt sql.NullTime
+	err := db.QueryRow(query, email).Scan(&user.ID, &user.Username, &user.Email, &user.JWTKey, &disabledAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("no user found with this email")
@@ -156,10 +178,15 @@ func (db *DB) RequestPasswordReset(email string) (*models.User, error) {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+
 	return user, nil
 }
 
-// ResetPassword changes a user's password and rotates their JWT key to invalidate existing tokens
+// ResetPassword changes a user's password, rotates their JWT key to invalidate existing tokens
+// and revokes their sessions
 func (db *DB) ResetPassword(userID int, newPassword string) error {
 	// Begin a transaction
 	tx, err := db.Begin()
@@ -186,6 +213,11 @@ func (db *DB) ResetPassword(userID int, newPassword string) error {
 		return fmt.Errorf("failed to update JWT key: %w", err)
 	}
 
+	// Log out every device
+	if err := revokeUserSessions(tx, userID); err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
@@ -193,3 +225,79 @@ func (db *DB) ResetPassword(userID int, newPassword string) error {
 
 	return nil
 }
+
+// SetUserDisabled disables or re-enables an account. Disabling rotates the
+// user's JWT key and revokes their sessions, so they are logged out everywhere.
+func (db *DB) SetUserDisabled(userID int, disabled bool) error {
+	return db.inTransaction(func(tx *sql.Tx) error {
+		var disabledAt interface{}
+		if disabled {
+			disabledAt = time.Now().UTC()
+		}
+
+		result, err := tx.Exec(`UPDATE users SET disabled_at = ? WHERE id = ?`, disabledAt, userID)
+		if err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rowsAffected == 0 {
+			return fmt.Errorf("user not found")
+		}
+
+		if !disabled {
+			return nil
+		}
+
+		newJWTKey, err := GenerateJWTKey()
+		if err != nil {
+			return fmt.Errorf("failed to generate new JWT key: %w", err)
+		}
+		if _, err := tx.Exec("UPDATE users SET jwt_key = ?, status = 'offline' WHERE id = ?", newJWTKey, userID); err != nil {
+			return fmt.Errorf("failed to update JWT key: %w", err)
+		}
+
+		return revokeUserSessions(tx, userID)
+	})
+}
+
+// GetDisabledUserIDs returns which of the given users are disabled
+func (db *DB) GetDisabledUserIDs(userIDs []int) ([]int, error) {
+	disabled := make([]int, 0)
+	if len(userIDs) == 0 {
+		return disabled, nil
+	}
+
+	placeholders := strings.Repeat("?,", len(userIDs))
+	placeholders = placeholders[:len(placeholders)-1]
+
+	query := fmt.Sprintf(`SELECT id FROM users WHERE disabled_at IS NOT NULL AND id IN (%s)`, placeholders)
+
+	args := make([]interface{}, len(userIDs))
+	for i, id := range userIDs {
+		args[i] = id
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disabled users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan disabled user: %w", err)
+		}
+		disabled = append(disabled, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating disabled users: %w", err)
+	}
+
+	return disabled, nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"sort"
 	"time"
 
 	"OurChat/internal/models"
@@ -32,71 +33,141 @@ func (db *DB) CreateChat(chatType, name string) (int64, error) {
 
 // AddUserToChat adds a user to a chat with the specified role
 func (db *DB) AddUserToChat(userID, chatID int, role string) error {
+	if err := addUserToChat(db, userID, chatID, role); err != nil {
+		return err
+	}
+
+	log.Println("User added to chat successfully")
+	return nil
+}
+
+// addUserToChat adds a user to a chat, inside or outside a transaction
+func addUserToChat(exec execer, userID, chatID int, role string) error {
 	query := `
 	INSERT INTO chat_members (user_id, chat_id, role, joined_at)
 	VALUES (?, ?, ?, ?)`
 
-	_, err := db.Exec(query, userID, chatID, role, time.Now())
+	_, err := exec.Exec(query, userID, chatID, role, time.Now())
 	if err != nil {
 		return fmt.Errorf("failed to add user to chat: %w", err)
 	}
 
-	log.Println("User added to chat successfully")
 	return nil
 }
 
-// GetChatsForUser retrieves all chats that a user is a member of
-func (db *DB) GetChatsForUser(userID int) ([]models.Chat, error) {
+// Maximum length of the last message content shown in the chat list
+const previewMaxLength = 100
+
+// GetChatsForUser retrieves all chats that a user is a member of, with their
+// unread count, last message and, for direct chats, the other participant.
+// Chats with the most recent activity come first. Archived chats are only
+// included if includeArchived is set.
+func (db *DB) GetChatsForUser(userID int, includeArchived bool) ([]models.ChatListItem, error) {
+	// Unread messages are the ones past the member's read cursor sent by others
 	query := `
-	SELECT c.id, c.type, c.name, c.created_at, c.updated_at, c.is_active
+	SELECT c.id, c.type, c.name, COALESCE(c.description, ''), c.avatar_url, c.created_at, c.updated_at, c.is_active,
+		(SELECT COUNT(*) FROM messages m
+		 WHERE m.chat_id = c.id AND m.id > COALESCE(cm.last_read_message_id, 0)
+		   AND m.sender_id != cm.user_id AND m.deleted_at IS NULL AND` + notHiddenForUser + `),
+		lm.id, lm.sender_id, lm.content, lm.message_type, lm.created_at, lm.deleted_at,
+		ou.id, ou.username, ou.status, ou.profile_picture_url, ou.last_seen_at, ou.hide_last_seen
 	FROM chats c
-	JOIN chat_members cm ON c.id = cm.chat_id
-	WHERE cm.user_id = ?`
-
-	rows, err := db.Query(query, userID)
+	JOIN chat_members cm ON c.id = cm.chat_id AND cm.user_id = ?
+	LEFT JOIN messages lm ON lm.id = (
+		SELECT MAX(m.id) FROM messages m
+		WHERE m.chat_id = c.id AND` + notHiddenForUser + `)
+	LEFT JOIN chat_members ocm ON c.type = 'direct' AND ocm.chat_id = c.id AND ocm.user_id != cm.user_id
+	LEFT JOIN users ou ON ocm.user_id = ou.id
+	WHERE c.is_active = TRUE OR ?`
+
+	rows, err := db.Query(query, userID, userID, userID, includeArchived)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get chats: %w", err)
 	}
 	defer rows.Close()
 
-	var chats []models.Chat
-	chats = make([]models.Chat, 0)
+	chats := make([]models.ChatListItem, 0)
 	for rows.Next() {
-		var chat models.Chat
-		if err := rows.Scan(&chat.ID, &chat.Type, &chat.Name, &chat.CreatedAt, &chat.UpdatedAt, &chat.IsActive); err != nil {
+		var chat models.ChatListItem
+		var lastID, lastSenderID sql.NullInt64
+		var lastContent, lastType sql.NullString
+		var lastCreatedAt, lastDeletedAt sql.NullTime
+		var otherID sql.NullInt64
+		var otherUsername, otherStatus, otherPicture sql.NullString
+		var otherLastSeenAt sql.NullTime
+		var otherHideLastSeen sql.NullBool
+
+		err := rows.Scan(
+			&chat.ID, &chat.Type, &chat.Name, &chat.Description, &chat.AvatarURL,
+			&chat.CreatedAt, &chat.UpdatedAt, &chat.IsActive,
+			&chat.UnreadCount,
+			&lastID, &lastSenderID, &lastContent, &lastType, &lastCreatedAt, &lastDeletedAt,
+			&otherID, &otherUsername, &otherStatus, &otherPicture, &otherLastSeenAt, &otherHideLastSeen,
+		)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan chat: %w", err)
 		}
-		chats = append(chats, chat)
-	}
 
-	for chat := range chats {
+		if lastID.Valid {
+			chat.LastMessage = &models.MessagePreview{
+				ID:          int(lastID.Int64),
+				SenderID:    int(lastSenderID.Int64),
+				Content:     truncateContent(lastContent.String, previewMaxLength),
+				MessageType: lastType.String,
+				CreatedAt:   lastCreatedAt.Time,
+				Deleted:     lastDeletedAt.Valid,
+			}
+		}
+
 		// Put the name of the other user in the chat name for direct chats
-		if chats[chat].Type == "direct" {
-			otherUserQuery := `
-			SELECT u.username FROM chat_members cm
-			JOIN users u ON cm.user_id = u.id
-			WHERE cm.chat_id = ? AND cm.user_id != ?`
-			var otherUsername string
-			err := db.QueryRow(otherUserQuery, chats[chat].ID, userID).Scan(&otherUsername)
-			if err != nil {
-				return nil, fmt.Errorf("failed to get other user for direct chat: %w", err)
+		if otherID.Valid {
+			chat.OtherUser = &models.UserBasic{
+				ID:           int(otherID.Int64),
+				Username:     otherUsername.String,
+				Status:       otherStatus.String,
+				HideLastSeen: otherHideLastSeen.Bool,
 			}
-			chats[chat].Name = otherUsername
+			if otherPicture.Valid {
+				chat.OtherUser.ProfilePictureURL = &otherPicture.String
+			}
+			if otherLastSeenAt.Valid {
+				chat.OtherUser.LastSeenAt = &otherLastSeenAt.Time
+			}
+			chat.Name = otherUsername.String
 		}
+
+		chats = append(chats, chat)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chats: %w", err)
 	}
 
+	// Sort by the last message, or by creation for chats without messages
+	sort.SliceStable(chats, func(i, j int) bool {
+		return lastActivity(chats[i]).After(lastActivity(chats[j]))
+	})
+
 	log.Println("Chats retrieved successfully")
 	return chats, nil
 }
 
+// lastActivity returns when something last happened in a chat
+func lastActivity(chat models.ChatListItem) time.Time {
+	if chat.LastMessage != nil {
+		return chat.LastMessage.CreatedAt
+	}
+	return chat.CreatedAt
+}
+
 // GetChatByID retrieves a chat by its ID
 func (db *DB) GetChatByID(chatID int) (*models.Chat, error) {
 	chat := &models.Chat{}
-	query := `SELECT id, type, name, created_at, updated_at, is_active
+	query := `SELECT id, type, name, COALESCE(description, ''), avatar_url, created_at, updated_at, is_active
 	          FROM chats WHERE id = ?`
 
 	err := db.QueryRow(query, chatID).Scan(
-		&chat.ID, &chat.Type, &chat.Name,
+		&chat.ID, &chat.Type, &chat.Name, &chat.Description, &chat.AvatarURL,
 		&chat.CreatedAt, &chat.UpdatedAt, &chat.IsActive,
 	)
 	if err != nil {
@@ -107,6 +178,56 @@ func (db *DB) GetChatByID(chatID int) (*models.Chat, error) {
 	return chat, nil
 }
 
+// UpdateChat updates the settings of a chat. Supported fields are name,
+// description and is_active.
+func (db *DB) UpdateChat(chatID int, updates map[string]interface{}) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for field, value := range updates {
+		var query string
+
+		switch field {
+		case "name":
+			query = "UPDATE chats SET name = ? WHERE id = ?"
+		case "description":
+			query = "UPDATE chats SET description = ? WHERE id = ?"
+		case "is_active":
+			query = "UPDATE chats SET is_active = ? WHERE id = ?"
+		default:
+			// Skip unsupported fields
+			continue
+		}
+
+		if _, err := tx.Exec(query, value, chatID); err != nil {
+			return fmt.Errorf("failed to update %s: %w", field, err)
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE chats SET updated_at = ? WHERE id = ?`, time.Now(), chatID); err != nil {
+		return fmt.Errorf("failed to update chat timestamp: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateChatAvatar updates a chat's avatar URL
+func (db *DB) UpdateChatAvatar(chatID int, avatarURL string) error {
+	query := `UPDATE chats SET avatar_url = ?, updated_at = ? WHERE id = ?`
+	_, err := db.Exec(query, avatarURL, time.Now(), chatID)
+	if err != nil {
+		return fmt.Errorf("failed to update chat avatar: %w", err)
+	}
+	return nil
+}
+
 // GetDirectChatBetweenUsers finds or creates a direct chat between two users
 func (db *DB) GetDirectChatBetweenUsers(userID1, userID2 int) (int, error) {
 	// First try to find an existing direct chat between these users
@@ -165,8 +286,8 @@ func (db *DB) GetDirectChatBetweenUsers(userID1, userID2 int) (int, error) {
 // GetChatMembers returns all users who are members of a specific chat
 func (db *DB) GetChatMembers(chatID int) ([]models.ChatMember, error) {
 	query := `
-    SELECT cm.id, cm.user_id, cm.chat_id, cm.role, cm.joined_at, cm.last_read_at,
-           u.username, u.status, u.profile_picture_url
+    SELECT cm.id, cm.user_id, cm.chat_id, cm.role, cm.joined_at, cm.last_read_at, cm.last_read_message_id,
+           u.username, u.status, u.profile_picture_url, u.last_seen_at, u.hide_last_seen
     FROM chat_members cm
     JOIN users u ON cm.user_id = u.id
     WHERE cm.chat_id = ?
@@ -182,7 +303,9 @@ func (db *DB) GetChatMembers(chatID int) ([]models.ChatMember, error) {
 	for rows.Next() {
 		var member models.ChatMember
 		var lastReadAt sql.NullTime
+		var lastReadMessageID sql.NullInt64
 		var profilePictureURL sql.NullString
+		var lastSeenAt sql.NullTime
 
 		err := rows.Scan(
 			&member.ID,
@@ -191,9 +314,12 @@ func (db *DB) GetChatMembers(chatID int) ([]models.ChatMember, error) {
 			&member.Role,
 			&member.JoinedAt,
 			&lastReadAt,
+			&lastReadMessageID,
 			&member.Username,
 			&member.Status,
 			&profilePictureURL,
+			&lastSeenAt,
+			&member.HideLastSeen,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan chat member: %w", err)
@@ -203,10 +329,19 @@ func (db *DB) GetChatMembers(chatID int) ([]models.ChatMember, error) {
 			member.LastReadAt = &lastReadAt.Time
 		}
 
+		if lastReadMessageID.Valid {
+			id := int(lastReadMessageID.Int64)
+			member.LastReadMessageID = &id
+		}
+
 		if profilePictureURL.Valid {
 			member.ProfilePictureURL = &profilePictureURL.String
 		}
 
+		if lastSeenAt.Valid {
+			member.LastSeenAt = &lastSeenAt.Time
+		}
+
 		members = append(members, member)
 	}
 
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"OurChat/internal/models"
+)
+
+// ErrInviteNoLongerValid is returned by RedeemChatInvite when the invite was
+// revoked, expired or used up
+var ErrInviteNoLongerValid = errors.New("invite is no longer valid")
+
+// generateInviteToken creates a random token that is safe to use in URLs
+func generateInviteToken() (string, error) {
+	bytes := make([]byte, 18)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// CreateChatInvite creates an invite for a chat with an optional expiry and
+// maximum number of uses
+func (db *DB) CreateChatInvite(chatID, createdBy int, expiresAt *time.Time, maxUses *int) (*models.ChatInvite, error) {
+	token, err := generateInviteToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps are compared as text in SQLite, so expiry is stored in UTC
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	query := `
+	INSERT INTO chat_invites (chat_id, token, created_by, created_at, expires_at, max_uses)
+	VALUES (?, ?, ?, ?, ?, ?)`
+
+	result, err := db.Exec(query, chatID, token, createdBy, time.Now(), expiresAt, maxUses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat invite: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+
+	log.Printf("Invite %d created for chat %d by user %d", id, chatID, createdBy)
+	return db.getChatInvite(`WHERE id = ?`, id)
+}
+
+// GetChatInviteByToken retrieves an invite by its token
+func (db *DB) GetChatInviteByToken(token string) (*models.ChatInvite, error) {
+	return db.getChatInvite(`WHERE token = ?`, token)
+}
+
+// getChatInvite retrieves a single invite matching the given condition
+func (db *DB) getChatInvite(condition string, args ...interface{}) (*models.ChatInvite, error) {
+	query := `
+	SELECT id, chat_id, token, created_by, created_at, expires_at, max_uses, use_count, revoked_at
+	FROM chat_invites ` + condition
+
+	invite, err := scanChatInvite(db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invite not found")
+		}
+		return nil, fmt.Errorf("failed to get chat invite: %w", err)
+	}
+
+	return invite, nil
+}
+
+// scanChatInvite scans a single chat invite row
+func scanChatInvite(row rowScanner) (*models.ChatInvite, error) {
+	invite := &models.ChatInvite{}
+	var expiresAt, revokedAt sql.NullTime
+	var maxUses sql.NullInt64
+
+	err := row.Scan(
+		&invite.ID, &invite.ChatID, &invite.Token, &invite.CreatedBy, &invite.CreatedAt,
+		&expiresAt, &maxUses, &invite.UseCount, &revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	if maxUses.Valid {
+		uses := int(maxUses.Int64)
+		invite.MaxUses = &uses
+	}
+	if revokedAt.Valid {
+		invite.RevokedAt = &revokedAt.Time
+	}
+
+	return invite, nil
+}
+
+// GetChatInvites retrieves all invites of a chat, newest first, together with
+// the users who joined through them
+func (db *DB) GetChatInvites(chatID int) ([]models.ChatInvite, error) {
+	query := `
+	SELECT id, chat_id, token, created_by, created_at, expires_at, max_uses, use_count, revoked_at
+	FROM chat_invites
+	WHERE chat_id = ?
+	ORDER BY created_at DESC, id DESC`
+
+	rows, err := db.Query(query, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat invites: %w", err)
+	}
+	defer rows.Close()
+
+	invites := make([]models.ChatInvite, 0)
+	index := make(map[int]int)
+	for rows.Next() {
+		invite, err := scanChatInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan chat invite: %w", err)
+		}
+		index[invite.ID] = len(invites)
+		invites = append(invites, *invite)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chat invites: %w", err)
+	}
+
+	usesQuery := `
+	SELECT ciu.invite_id, ciu.user_id, u.username, ciu.used_at
+	FROM chat_invite_uses ciu
+	JOIN chat_invites ci ON ciu.invite_id = ci.id
+	JOIN users u ON ciu.user_id = u.id
+	WHERE ci.chat_id = ?
+	ORDER BY ciu.used_at ASC`
+
+	useRows, err := db.Query(usesQuery, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat invite uses: %w", err)
+	}
+	defer useRows.Close()
+
+	for useRows.Next() {
+		var inviteID int
+		var use models.ChatInviteUse
+		if err := useRows.Scan(&inviteID, &use.UserID, &use.Username, &use.UsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan chat invite use: %w", err)
+		}
+		if i, ok := index[inviteID]; ok {
+			invites[i].Uses = append(invites[i].Uses, use)
+		}
+	}
+
+	if err := useRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chat invite uses: %w", err)
+	}
+
+	return invites, nil
+}
+
+// RevokeChatInvite revokes an invite of a chat so it can't be used anymore
+func (db *DB) RevokeChatInvite(inviteID, chatID int) error {
+	query := `
+	UPDATE chat_invites SET revoked_at = ?
+	WHERE id = ? AND chat_id = ? AND revoked_at IS NULL`
+
+	result, err := db.Exec(query, time.Now(), inviteID, chatID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke chat invite: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("invite not found or already revoked")
+	}
+
+	return nil
+}
+
+// RedeemChatInvite records a use of an invite by a user and adds them to the
+// invite's chat as a member, all or nothing. It fails if the invite was
+// revoked, expired or used up in the meantime, with ErrInviteNoLongerValid.
+func (db *DB) RedeemChatInvite(inviteID, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	// Check and count the use in one statement so concurrent joins can't
+	// exceed the maximum number of uses
+	query := `
+	UPDATE chat_invites SET use_count = use_count + 1
+	WHERE id = ? AND revoked_at IS NULL
+	  AND (expires_at IS NULL OR expires_at > ?)
+	  AND (max_uses IS NULL OR use_count < max_uses)`
+
+	result, err := tx.Exec(query, inviteID, now)
+	if err != nil {
+		return fmt.Errorf("failed to redeem chat invite: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrInviteNoLongerValid
+	}
+
+	// A member who left and rejoins with the same invite uses it again
+	useQuery := `
+	INSERT INTO chat_invite_uses (invite_id, user_id, used_at) VALUES (?, ?, ?)
+	ON CONFLICT (invite_id, user_id) DO UPDATE SET used_at = excluded.used_at`
+
+	if _, err := tx.Exec(useQuery, inviteID, userID, now); err != nil {
+		return fmt.Errorf("failed to record chat invite use: %w", err)
+	}
+
+	var chatID int
+	if err := tx.QueryRow(`SELECT chat_id FROM chat_invites WHERE id = ?`, inviteID).Scan(&chatID); err != nil {
+		return fmt.Errorf("failed to get chat of invite: %w", err)
+	}
+
+	if err := addUserToChat(tx, userID, chatID, "member"); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -2,9 +2,18 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 )
 
+var (
+	// ErrNotChatMember is returned when changing a membership that doesn't exist
+	ErrNotChatMember = errors.New("user is not a member of this chat")
+	// ErrLastChatAdmin is returned when a change would leave a chat with
+	// members but no admin
+	ErrLastChatAdmin = errors.New("chat needs at least one admin")
+)
+
 // IsUserChatMember checks if a user is a member of a specific chat
 func (db *DB) IsUserChatMember(userID, chatID int) (bool, string, error) {
 	query := `
@@ -24,3 +33,117 @@ func (db *DB) IsUserChatMember(userID, chatID int) (bool, string, error) {
 	// User is a member of the chat
 	return true, role, nil
 }
+
+// GetContactIDs returns the IDs of all users who share at least one chat with the user
+func (db *DB) GetContactIDs(userID int) ([]int, error) {
+	query := `
+	SELECT DISTINCT cm2.user_id
+	FROM chat_members cm1
+	JOIN chat_members cm2 ON cm1.chat_id = cm2.chat_id
+	WHERE cm1.user_id = ? AND cm2.user_id != ?`
+
+	rows, err := db.Query(query, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contacts: %w", err)
+	}
+	defer rows.Close()
+
+	contactIDs := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan contact: %w", err)
+		}
+		contactIDs = append(contactIDs, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating contacts: %w", err)
+	}
+
+	return contactIDs, nil
+}
+
+// RemoveUserFromChat removes a user from a chat. The last admin can only be
+// removed once nobody else is left, otherwise ErrLastChatAdmin is returned.
+func (db *DB) RemoveUserFromChat(userID, chatID int) error {
+	return db.inTransaction(func(tx *sql.Tx) error {
+		role, err := chatMemberRole(tx, userID, chatID)
+		if err != nil {
+			return err
+		}
+
+		if role == "admin" {
+			admins, members, err := countChatAdmins(tx, chatID)
+			if err != nil {
+				return err
+			}
+			if admins <= 1 && members > 1 {
+				return ErrLastChatAdmin
+			}
+		}
+
+		if _, err := tx.Exec(`DELETE FROM chat_members WHERE user_id = ? AND chat_id = ?`, userID, chatID); err != nil {
+			return fmt.Errorf("failed to remove user from chat: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// UpdateChatMemberRole changes the role of a chat member. Demoting the last
+// admin returns ErrLastChatAdmin.
+func (db *DB) UpdateChatMemberRole(userID, chatID int, role string) error {
+	return db.inTransaction(func(tx *sql.Tx) error {
+		currentRole, err := chatMemberRole(tx, userID, chatID)
+		if err != nil {
+			return err
+		}
+
+		if currentRole == "admin" && role != "admin" {
+			admins, _, err := countChatAdmins(tx, chatID)
+			if err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return ErrLastChatAdmin
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE chat_members SET role = ? WHERE user_id = ? AND chat_id = ?`, role, userID, chatID); err != nil {
+			return fmt.Errorf("failed to update member role: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// chatMemberRole returns the role of a chat member within a transaction
+func chatMemberRole(tx *sql.Tx, userID, chatID int) (string, error) {
+	var role string
+	err := tx.QueryRow(`SELECT role FROM chat_members WHERE user_id = ? AND chat_id = ?`, userID, chatID).Scan(&role)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotChatMember
+		}
+		return "", fmt.Errorf("failed to check chat membership: %w", err)
+	}
+
+	return role, nil
+}
+
+// countChatAdmins returns the number of admins and of all members of a chat.
+// Counting in the transaction that changes a membership keeps concurrent
+// changes from removing the last admin.
+func countChatAdmins(tx *sql.Tx, chatID int) (admins, members int, err error) {
+	query := `
+	SELECT COUNT(CASE WHEN role = 'admin' THEN 1 END), COUNT(*)
+	FROM chat_members
+	WHERE chat_id = ?`
+
+	if err := tx.QueryRow(query, chatID).Scan(&admins, &members); err != nil {
+		return 0, 0, fmt.Errorf("failed to count chat admins: %w", err)
+	}
+
+	return admins, members, nil
+}
@@ -14,10 +14,30 @@ import (
 // DB wraps a sql.DB connection and provides access to all database operations
 type DB struct {
 	*sql.DB
+
+	// Whether SQLite was built with FTS5. Message search falls back to
+	// LIKE scans without it.
+	fullTextSearch bool
 }
 
-// NewDB initializes a new database connection and loads the schema if needed
+// NewDB initializes a new database connection and brings the schema up to date
 func NewDB(dbPath string) (*DB, error) {
+	database, err := Open(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply pending migrations
+	if err := database.LoadSchemaIfNeeded(); err != nil {
+		database.Close()
+		return nil, fmt.Errorf("failed to load schema: %w", err)
+	}
+
+	return database, nil
+}
+
+// Open opens a database connection without touching the schema
+func Open(dbPath string) (*DB, error) {
 	// Ensure database directory exists
 	dbDir := filepath.Dir(dbPath)
 	if _, err := os.Stat(dbDir); os.IsNotExist(err) {
@@ -34,18 +54,11 @@ func NewDB(dbPath string) (*DB, error) {
 
 	// Test the connection
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Create DB wrapper
-	database := &DB{db}
-
-	// Load schema if tables don't exist
-	if err := database.LoadSchemaIfNeeded(); err != nil {
-		return nil, fmt.Errorf("failed to load schema: %w", err)
-	}
-
-	return database, nil
+	return &DB{DB: db}, nil
 }
 
 // Close closes the database connection
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB opens a migrated database in a temporary directory
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	database, err := NewDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	return database
+}
+
+// createTestUser adds a user and returns their ID
+func createTestUser(t *testing.T, database *DB, username string) int {
+	t.Helper()
+
+	if err := database.CreateUser(username, username+"@example.com", "password"); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	user, err := database.GetUserByUsername(username)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+
+	return user.ID
+}
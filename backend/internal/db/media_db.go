@@ -2,11 +2,15 @@ package db
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"OurChat/internal/models"
 )
 
+// ErrMediaFileNotFound is returned when no media file matches a lookup
+var ErrMediaFileNotFound = errors.New("media file not found")
+
 // CreateMediaFile saves media file metadata to the database
 func (db *DB) CreateMediaFile(mediaFile *models.MediaFile) (int64, error) {
 	query := `
@@ -54,7 +58,7 @@ func (db *DB) GetMediaFileByID(mediaFileID int) (*models.MediaFile, error) {
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("media file not found")
+			return nil, ErrMediaFileNotFound
 		}
 		return nil, fmt.Errorf("failed to get media file: %w", err)
 	}
@@ -82,7 +86,7 @@ func (db *DB) GetMediaFileByFilename(filename string) (*models.MediaFile, error)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("media file not found")
+			return nil, ErrMediaFileNotFound
 		}
 		return nil, fmt.Errorf("failed to get media file: %w", err)
 	}
@@ -9,12 +9,13 @@ import (
 	"OurChat/internal/models"
 )
 
-func (db *DB) CreateMessage(senderID, chatID int, content, messageType string, mediaFileID *int) (int64, error) {
+// CreateMessage creates a message, optionally replying to another message
+func (db *DB) CreateMessage(senderID, chatID int, content, messageType string, mediaFileID, replyToMessageID *int) (int64, error) {
 	query := `
-	INSERT INTO messages (sender_id, chat_id, content, message_type, media_file_id, created_at)
-	VALUES (?, ?, ?, ?, ?, ?)`
+	INSERT INTO messages (sender_id, chat_id, content, message_type, media_file_id, reply_to_message_id, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?)`
 
-	result, err := db.Exec(query, senderID, chatID, content, messageType, mediaFileID, time.Now())
+	result, err := db.Exec(query, senderID, chatID, content, messageType, mediaFileID, replyToMessageID, time.Now())
 	if err != nil {
 		return 0, fmt.Errorf("failed to create message: %w", err)
 	}
@@ -35,33 +36,67 @@ func (db *DB) CreateMessage(senderID, chatID int, content, messageType string, m
 	return id, nil
 }
 
-// GetMessageByID retrieves a specific message by its ID
-func (db *DB) GetMessageByIDWithMedia(messageID int) (*models.Message, error) {
-	message := &models.Message{}
-	query := `
-	SELECT m.id, m.sender_id, m.chat_id, m.content, m.message_type, m.media_file_id, m.created_at, m.is_read,
-	       mf.id, mf.filename, mf.original_filename, mf.file_size, mf.mime_type, mf.uploaded_at
-	FROM messages m
-	LEFT JOIN media_files mf ON m.media_file_id = mf.id
-	WHERE m.id = ?`
+// messageWithMediaColumns selects a message joined with its media file and
+// the message it replies to. Queries using it must alias messages as m and
+// add messageWithMediaJoins after FROM messages m.
+const messageWithMediaColumns = `
+	m.id, m.sender_id, m.chat_id, m.content, m.message_type, m.media_file_id, m.created_at,` + readByCount + `,
+	m.edited_at, m.deleted_at, m.deleted_by, m.reply_to_message_id,
+	(SELECT COUNT(*) FROM messages r WHERE r.reply_to_message_id = m.id AND r.deleted_at IS NULL),
+	rm.id, rm.sender_id, rm.content, rm.message_type, rm.deleted_at,
+	mf.id, mf.filename, mf.original_filename, mf.file_size, mf.mime_type, mf.uploaded_at`
+
+// readByCount counts the members other than the sender whose read cursor
+// reached a message. It expects messages aliased as m.
+const readByCount = `
+	(SELECT COUNT(*) FROM chat_members rc
+	 WHERE rc.chat_id = m.chat_id AND rc.user_id != m.sender_id AND rc.last_read_message_id >= m.id)`
+
+// messageWithMediaJoins joins the tables messageWithMediaColumns selects from
+const messageWithMediaJoins = `
+	LEFT JOIN messages rm ON m.reply_to_message_id = rm.id
+	LEFT JOIN media_files mf ON m.media_file_id = mf.id`
+
+// Maximum length of the content quoted in a reply
+const quoteMaxLength = 200
+
+// truncateContent shortens message content to at most maxLength characters,
+// marking cut content with an ellipsis
+func truncateContent(content string, maxLength int) string {
+	runes := []rune(content)
+	if len(runes) <= maxLength {
+		return content
+	}
+	return string(append(runes[:maxLength], '…'))
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
+// scanMessageWithMedia scans a row selected with messageWithMediaColumns
+func scanMessageWithMedia(row rowScanner) (*models.Message, error) {
+	message := &models.Message{}
 	var mediaFileID, mediaID sql.NullInt64
 	var mediaFilename, mediaOriginalFilename, mediaMimeType sql.NullString
 	var mediaFileSize sql.NullInt64
-	var mediaUploadedAt sql.NullTime
+	var mediaUploadedAt, editedAt, deletedAt sql.NullTime
+	var deletedBy, replyToID sql.NullInt64
+	var quoteID, quoteSenderID sql.NullInt64
+	var quoteContent, quoteType sql.NullString
+	var quoteDeletedAt sql.NullTime
 
-	err := db.QueryRow(query, messageID).Scan(
+	err := row.Scan(
 		&message.ID, &message.SenderID, &message.ChatID, &message.Content,
-		&message.MessageType, &mediaFileID, &message.CreatedAt, &message.IsRead,
+		&message.MessageType, &mediaFileID, &message.CreatedAt, &message.ReadBy,
+		&editedAt, &deletedAt, &deletedBy, &replyToID, &message.ReplyCount,
+		&quoteID, &quoteSenderID, &quoteContent, &quoteType, &quoteDeletedAt,
 		&mediaID, &mediaFilename, &mediaOriginalFilename, &mediaFileSize,
 		&mediaMimeType, &mediaUploadedAt,
 	)
-
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("message not found")
-		}
-		return nil, fmt.Errorf("failed to get message: %w", err)
+		return nil, err
 	}
 
 	// Set media file ID if it exists
@@ -70,6 +105,38 @@ func (db *DB) GetMessageByIDWithMedia(messageID int) (*models.Message, error) {
 		message.MediaFileID = &id
 	}
 
+	if editedAt.Valid {
+		message.EditedAt = &editedAt.Time
+	}
+
+	if deletedAt.Valid {
+		message.DeletedAt = &deletedAt.Time
+	}
+
+	if deletedBy.Valid {
+		id := int(deletedBy.Int64)
+		message.DeletedBy = &id
+	}
+
+	// Without a viewer, read means read from the sender's point of view
+	message.IsRead = message.ReadBy > 0
+
+	if replyToID.Valid {
+		id := int(replyToID.Int64)
+		message.ReplyToMessageID = &id
+	}
+
+	// Populate the quoted message if it still exists
+	if quoteID.Valid {
+		message.ReplyTo = &models.MessageQuote{
+			ID:          int(quoteID.Int64),
+			SenderID:    int(quoteSenderID.Int64),
+			Content:     truncateContent(quoteContent.String, quoteMaxLength),
+			MessageType: quoteType.String,
+			Deleted:     quoteDeletedAt.Valid,
+		}
+	}
+
 	// Populate media file information if it exists
 	if mediaID.Valid {
 		message.MediaFile = &models.MediaFile{
@@ -86,18 +153,40 @@ func (db *DB) GetMessageByIDWithMedia(messageID int) (*models.Message, error) {
 	return message, nil
 }
 
-// GetMessagesByChatID retrieves messages from a chat with pagination
-func (db *DB) GetMessagesByChatIDWithMedia(chatID int, limit, offset int) ([]models.Message, error) {
+// GetMessageByIDWithMedia retrieves a specific message by its ID
+func (db *DB) GetMessageByIDWithMedia(messageID int) (*models.Message, error) {
 	query := `
-	SELECT m.id, m.sender_id, m.chat_id, m.content, m.message_type, m.media_file_id, m.created_at, m.is_read,
-	       mf.id, mf.filename, mf.original_filename, mf.file_size, mf.mime_type, mf.uploaded_at
-	FROM messages m
-	LEFT JOIN media_files mf ON m.media_file_id = mf.id
-	WHERE m.chat_id = ?
+	SELECT` + messageWithMediaColumns + `
+	FROM messages m` + messageWithMediaJoins + `
+	WHERE m.id = ?`
+
+	message, err := scanMessageWithMedia(db.QueryRow(query, messageID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("message not found")
+		}
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	return message, nil
+}
+
+// notHiddenForUser filters out messages the user deleted for themselves.
+// It expects messages aliased as m and takes the user ID as its parameter.
+const notHiddenForUser = `
+	NOT EXISTS (SELECT 1 FROM hidden_messages hm WHERE hm.message_id = m.id AND hm.user_id = ?)`
+
+// GetMessagesByChatIDWithMedia retrieves the messages of a chat visible to a
+// user with pagination. Deleted messages are returned as tombstones.
+func (db *DB) GetMessagesByChatIDWithMedia(chatID, userID int, limit, offset int) ([]models.Message, error) {
+	query := `
+	SELECT` + messageWithMediaColumns + `
+	FROM messages m` + messageWithMediaJoins + `
+	WHERE m.chat_id = ? AND` + notHiddenForUser + `
 	ORDER BY m.created_at DESC
 	LIMIT ? OFFSET ?`
 
-	rows, err := db.Query(query, chatID, limit, offset)
+	rows, err := db.Query(query, chatID, userID, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
@@ -105,42 +194,246 @@ func (db *DB) GetMessagesByChatIDWithMedia(chatID int, limit, offset int) ([]mod
 
 	messages := make([]models.Message, 0)
 	for rows.Next() {
-		var message models.Message
-		var mediaFileID, mediaID sql.NullInt64
-		var mediaFilename, mediaOriginalFilename, mediaMimeType sql.NullString
-		var mediaFileSize sql.NullInt64
-		var mediaUploadedAt sql.NullTime
-
-		err := rows.Scan(
-			&message.ID, &message.SenderID, &message.ChatID, &message.Content,
-			&message.MessageType, &mediaFileID, &message.CreatedAt, &message.IsRead,
-			&mediaID, &mediaFilename, &mediaOriginalFilename, &mediaFileSize,
-			&mediaMimeType, &mediaUploadedAt,
-		)
+		message, err := scanMessageWithMedia(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
+		messages = append(messages, *message)
+	}
+
+	if err := db.applyReadState(messages, userID); err != nil {
+		return nil, err
+	}
+
+	if err := db.attachReactions(messages, userID); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// MessageCursor positions a page of chat messages. At most one of the IDs is
+// set; with none of them the page ends at the newest message.
+type MessageCursor struct {
+	BeforeID int // Messages older than this one
+	AfterID  int // Messages newer than this one
+	AroundID int // This message and the messages around it
+}
+
+// GetMessagesPage retrieves a page of the messages of a chat visible to a
+// user, newest first. Pages are positioned by message ID rather than an offset
+// so new messages don't shift them.
+func (db *DB) GetMessagesPage(chatID, userID int, cursor MessageCursor, limit int) (*models.MessagePage, error) {
+	page := &models.MessagePage{}
+
+	// Older messages are fetched newest first, newer ones oldest first. One
+	// extra message is fetched in each direction to see whether there are more.
+	var older, newer []models.Message
+	olderLimit, newerLimit := limit, limit
+	var err error
+
+	switch {
+	case cursor.AfterID > 0:
+		newer, err = db.getChatMessagesPart(chatID, userID, "m.id > ?", cursor.AfterID, "ASC", newerLimit+1)
+		if err != nil {
+			return nil, err
+		}
+		page.HasMoreBefore, err = db.hasChatMessages(chatID, userID, "m.id <= ?", cursor.AfterID)
 
-		// Set media file ID if it exists
-		if mediaFileID.Valid {
-			id := int(mediaFileID.Int64)
-			message.MediaFileID = &id
+	case cursor.AroundID > 0:
+		// Put the message in the middle, leaning towards newer messages
+		olderLimit = limit / 2
+		newerLimit = limit - olderLimit
+		older, err = db.getChatMessagesPart(chatID, userID, "m.id < ?", cursor.AroundID, "DESC", olderLimit+1)
+		if err != nil {
+			return nil, err
 		}
+		newer, err = db.getChatMessagesPart(chatID, userID, "m.id >= ?", cursor.AroundID, "ASC", newerLimit+1)
 
-		// Populate media file information if it exists
-		if mediaID.Valid {
-			message.MediaFile = &models.MediaFile{
-				ID:               int(mediaID.Int64),
-				Filename:         mediaFilename.String,
-				OriginalFilename: mediaOriginalFilename.String,
-				FileSize:         mediaFileSize.Int64,
-				MimeType:         mediaMimeType.String,
-				UploadedAt:       mediaUploadedAt.Time,
-				URL:              fmt.Sprintf("/api/media/files/%s", mediaFilename.String),
-			}
+	case cursor.BeforeID > 0:
+		older, err = db.getChatMessagesPart(chatID, userID, "m.id < ?", cursor.BeforeID, "DESC", olderLimit+1)
+		if err != nil {
+			return nil, err
 		}
+		page.HasMoreAfter, err = db.hasChatMessages(chatID, userID, "m.id >= ?", cursor.BeforeID)
 
-		messages = append(messages, message)
+	default:
+		older, err = db.getChatMessagesPart(chatID, userID, "m.id > ?", 0, "DESC", olderLimit+1)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(older) > olderLimit {
+		older = older[:olderLimit]
+		page.HasMoreBefore = true
+	}
+	if len(newer) > newerLimit {
+		newer = newer[:newerLimit]
+		page.HasMoreAfter = true
+	}
+
+	// Combine both parts newest first
+	page.Messages = make([]models.Message, 0, len(older)+len(newer))
+	for i := len(newer) - 1; i >= 0; i-- {
+		page.Messages = append(page.Messages, newer[i])
+	}
+	page.Messages = append(page.Messages, older...)
+
+	if len(page.Messages) > 0 {
+		newest := page.Messages[0].ID
+		oldest := page.Messages[len(page.Messages)-1].ID
+		page.NextCursor = &newest
+		page.PrevCursor = &oldest
+	}
+
+	if err := db.applyReadState(page.Messages, userID); err != nil {
+		return nil, err
+	}
+
+	if err := db.attachReactions(page.Messages, userID); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// getChatMessagesPart retrieves the visible messages of a chat whose IDs match
+// the condition, ordered by ID in the given direction
+func (db *DB) getChatMessagesPart(chatID, userID int, condition string, cursorID int, order string, limit int) ([]models.Message, error) {
+	query := `
+	SELECT` + messageWithMediaColumns + `
+	FROM messages m` + messageWithMediaJoins + `
+	WHERE m.chat_id = ? AND ` + condition + ` AND` + notHiddenForUser + `
+	ORDER BY m.id ` + order + `
+	LIMIT ?`
+
+	rows, err := db.Query(query, chatID, cursorID, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]models.Message, 0)
+	for rows.Next() {
+		message, err := scanMessageWithMedia(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, *message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// hasChatMessages checks whether the chat has visible messages whose IDs match
+// the condition
+func (db *DB) hasChatMessages(chatID, userID int, condition string, cursorID int) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM messages m
+		WHERE m.chat_id = ? AND ` + condition + ` AND` + notHiddenForUser + `
+	)`
+
+	var exists bool
+	if err := db.QueryRow(query, chatID, cursorID, userID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("failed to check for messages: %w", err)
+	}
+
+	return exists, nil
+}
+
+// GetMessagesForUserSince retrieves messages newer than afterID from every chat
+// the user is a member of, oldest first
+func (db *DB) GetMessagesForUserSince(userID, afterID, limit int) ([]models.Message, error) {
+	query := `
+	SELECT` + messageWithMediaColumns + `
+	FROM messages m
+	JOIN chat_members cm ON m.chat_id = cm.chat_id AND cm.user_id = ?` + messageWithMediaJoins + `
+	WHERE m.id > ? AND` + notHiddenForUser + `
+	ORDER BY m.id ASC
+	LIMIT ?`
+
+	rows, err := db.Query(query, userID, afterID, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]models.Message, 0)
+	for rows.Next() {
+		message, err := scanMessageWithMedia(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, *message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+
+	if err := db.applyReadState(messages, userID); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetLatestMessageIDForUser returns the ID of the newest message in any chat
+// the user is a member of, or 0 if there is none
+func (db *DB) GetLatestMessageIDForUser(userID int) (int, error) {
+	query := `
+	SELECT COALESCE(MAX(m.id), 0)
+	FROM messages m
+	JOIN chat_members cm ON m.chat_id = cm.chat_id AND cm.user_id = ?`
+
+	var messageID int
+	if err := db.QueryRow(query, userID).Scan(&messageID); err != nil {
+		return 0, fmt.Errorf("failed to get latest message ID: %w", err)
+	}
+
+	return messageID, nil
+}
+
+// GetRepliesWithMedia retrieves the replies to a message visible to a user,
+// oldest first
+func (db *DB) GetRepliesWithMedia(messageID, userID int) ([]models.Message, error) {
+	query := `
+	SELECT` + messageWithMediaColumns + `
+	FROM messages m` + messageWithMediaJoins + `
+	WHERE m.reply_to_message_id = ? AND` + notHiddenForUser + `
+	ORDER BY m.created_at ASC, m.id ASC`
+
+	rows, err := db.Query(query, messageID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get replies: %w", err)
+	}
+	defer rows.Close()
+
+	messages := make([]models.Message, 0)
+	for rows.Next() {
+		message, err := scanMessageWithMedia(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, *message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating replies: %w", err)
+	}
+
+	if err := db.applyReadState(messages, userID); err != nil {
+		return nil, err
+	}
+
+	if err := db.attachReactions(messages, userID); err != nil {
+		return nil, err
 	}
 
 	return messages, nil
@@ -149,7 +442,7 @@ func (db *DB) GetMessagesByChatIDWithMedia(chatID int, limit, offset int) ([]mod
 // GetMessagesByUserID retrieves all messages that a user can access
 func (db *DB) GetMessagesByUserID(userID int) ([]models.Message, error) {
 	query := `
-	SELECT m.id, m.sender_id, m.chat_id, m.content, m.created_at, m.is_read
+	SELECT m.id, m.sender_id, m.chat_id, m.content, m.created_at,` + readByCount + ` > 0
 	FROM messages m
 	JOIN chat_members cm ON m.chat_id = cm.chat_id
 	WHERE cm.user_id = ?
@@ -178,10 +471,10 @@ func (db *DB) GetMessagesByUserID(userID int) ([]models.Message, error) {
 // GetMessagesByUserInChat retrieves all messages sent by a specific user in a chat
 func (db *DB) GetMessagesByUserInChat(userID, chatID int) ([]models.Message, error) {
 	query := `
-	SELECT id, sender_id, chat_id, content, created_at, is_read
-	FROM messages
-	WHERE sender_id = ? AND chat_id = ?
-	ORDER BY created_at DESC`
+	SELECT m.id, m.sender_id, m.chat_id, m.content, m.created_at,` + readByCount + ` > 0
+	FROM messages m
+	WHERE m.sender_id = ? AND m.chat_id = ?
+	ORDER BY m.created_at DESC`
 
 	rows, err := db.Query(query, userID, chatID)
 	if err != nil {
@@ -203,71 +496,150 @@ func (db *DB) GetMessagesByUserInChat(userID, chatID int) ([]models.Message, err
 	return messages, nil
 }
 
-// SearchMessages searches for messages containing specific text
-func (db *DB) SearchMessages(chatID int, searchText string) ([]models.Message, error) {
+// MarkMessagesAsRead moves the user's read cursor in a chat forward to the
+// given message, or to the latest message if upToMessageID is 0. The cursor
+// never moves back. It returns the resulting cursor.
+func (db *DB) MarkMessagesAsRead(userID, chatID, upToMessageID int) (int, error) {
+	if upToMessageID == 0 {
+		err := db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM messages WHERE chat_id = ?`, chatID).Scan(&upToMessageID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get latest message: %w", err)
+		}
+	}
+
 	query := `
-	SELECT id, sender_id, chat_id, content, created_at, is_read
-	FROM messages
-	WHERE chat_id = ? AND content LIKE ?
-	ORDER BY created_at DESC`
+	UPDATE chat_members
+	SET last_read_message_id = MAX(COALESCE(last_read_message_id, 0), ?), last_read_at = ?
+	WHERE user_id = ? AND chat_id = ?`
 
-	// Add wildcards for SQL LIKE
-	searchPattern := "%" + searchText + "%"
+	_, err := db.Exec(query, upToMessageID, time.Now(), userID, chatID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark messages as read: %w", err)
+	}
 
-	rows, err := db.Query(query, chatID, searchPattern)
+	var lastReadMessageID int
+	err = db.QueryRow(`
+	SELECT COALESCE(last_read_message_id, 0) FROM chat_members
+	WHERE user_id = ? AND chat_id = ?`, userID, chatID).Scan(&lastReadMessageID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search messages: %w", err)
+		return 0, fmt.Errorf("failed to get read cursor: %w", err)
+	}
+
+	return lastReadMessageID, nil
+}
+
+// applyReadState sets IsRead from the user's point of view: their own
+// messages count as read once another member read them, everyone else's once
+// the user's read cursor reached them
+func (db *DB) applyReadState(messages []models.Message, userID int) error {
+	rows, err := db.Query(`
+	SELECT chat_id, COALESCE(last_read_message_id, 0) FROM chat_members
+	WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get read cursors: %w", err)
 	}
 	defer rows.Close()
 
-	var messages []models.Message
+	cursors := make(map[int]int)
 	for rows.Next() {
-		var message models.Message
-		if err := rows.Scan(&message.ID, &message.SenderID, &message.ChatID, &message.Content,
-			&message.CreatedAt, &message.IsRead); err != nil {
-			return nil, fmt.Errorf("failed to scan message: %w", err)
+		var chatID, lastReadMessageID int
+		if err := rows.Scan(&chatID, &lastReadMessageID); err != nil {
+			return fmt.Errorf("failed to scan read cursor: %w", err)
 		}
-		messages = append(messages, message)
+		cursors[chatID] = lastReadMessageID
 	}
 
-	log.Printf("Found %d messages matching '%s' in chat %d", len(messages), searchText, chatID)
-	return messages, nil
-}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating read cursors: %w", err)
+	}
 
-// MarkMessagesAsRead marks all messages in a chat as read for a user
-func (db *DB) MarkMessagesAsRead(userID, chatID int) error {
-	query := `
-	UPDATE messages
-	SET is_read = TRUE
-	WHERE chat_id = ? AND sender_id != ? AND is_read = FALSE`
+	for i := range messages {
+		if messages[i].SenderID != userID {
+			messages[i].IsRead = messages[i].ID <= cursors[messages[i].ChatID]
+		}
+	}
+
+	return nil
+}
 
-	result, err := db.Exec(query, chatID, userID)
+// EditMessage replaces the content of a message and keeps the previous
+// content as a revision in message_edits
+func (db *DB) EditMessage(messageID, editorID int, content string) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to mark messages as read: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	var previousContent string
+	err = tx.QueryRow(`SELECT content FROM messages WHERE id = ?`, messageID).Scan(&previousContent)
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("message not found")
+		}
+		return fmt.Errorf("failed to get message: %w", err)
 	}
 
-	// Update the last_read_at timestamp for the user in this chat
-	updateQuery := `
-	UPDATE chat_members
-	SET last_read_at = ?
-	WHERE user_id = ? AND chat_id = ?`
+	now := time.Now()
+
+	// Store the previous revision
+	_, err = tx.Exec(`
+	INSERT INTO message_edits (message_id, content, edited_by, edited_at)
+	VALUES (?, ?, ?, ?)`, messageID, previousContent, editorID, now)
+	if err != nil {
+		return fmt.Errorf("failed to store message revision: %w", err)
+	}
 
-	_, err = db.Exec(updateQuery, time.Now(), userID, chatID)
+	// Update the message itself
+	_, err = tx.Exec(`UPDATE messages SET content = ?, edited_at = ? WHERE id = ?`, content, now, messageID)
 	if err != nil {
-		log.Printf("Warning: failed to update last_read timestamp: %v", err)
+		return fmt.Errorf("failed to update message: %w", err)
 	}
 
-	log.Printf("%d messages marked as read", rowsAffected)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("Message %d edited by user %d", messageID, editorID)
 	return nil
 }
 
-// DeleteMessage deletes a message (if the user is the sender or an admin)
-func (db *DB) DeleteMessage(messageID, userID int) error {
+// GetMessageEdits retrieves the previous revisions of a message, oldest first
+func (db *DB) GetMessageEdits(messageID int) ([]models.MessageEdit, error) {
+	query := `
+	SELECT id, message_id, content, edited_by, edited_at
+	FROM message_edits
+	WHERE message_id = ?
+	ORDER BY edited_at ASC, id ASC`
+
+	rows, err := db.Query(query, messageID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message edits: %w", err)
+	}
+	defer rows.Close()
+
+	edits := make([]models.MessageEdit, 0)
+	for rows.Next() {
+		var edit models.MessageEdit
+		if err := rows.Scan(&edit.ID, &edit.MessageID, &edit.Content, &edit.EditedBy, &edit.EditedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message edit: %w", err)
+		}
+		edits = append(edits, edit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message edits: %w", err)
+	}
+
+	return edits, nil
+}
+
+// DeleteMessage deletes a message for everyone (if the user is the sender or
+// an admin). The row is kept as a tombstone so clients that already fetched
+// the message learn it is gone; its content, media and edit history are dropped.
+// If no other message shares its media file, the file's row is deleted too and
+// the path of the file is returned, for the caller to remove from disk.
+func (db *DB) DeleteMessage(messageID, userID int) (string, error) {
 	// First check if user is sender or admin
 	query := `
 	SELECT m.id
@@ -279,18 +651,101 @@ func (db *DB) DeleteMessage(messageID, userID int) error {
 	err := db.QueryRow(query, userID, messageID, userID).Scan(&id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return fmt.Errorf("not authorized to delete this message")
+			return "", fmt.Errorf("not authorized to delete this message")
 		}
-		return fmt.Errorf("failed to check message permissions: %w", err)
+		return "", fmt.Errorf("failed to check message permissions: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var mediaFileID sql.NullInt64
+	err = tx.QueryRow(`SELECT media_file_id FROM messages WHERE id = ?`, messageID).Scan(&mediaFileID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get message media: %w", err)
+	}
+
+	// Turn the message into a tombstone
+	tombstoneQuery := `
+	UPDATE messages
+	SET content = '', media_file_id = NULL, deleted_at = ?, deleted_by = ?
+	WHERE id = ? AND deleted_at IS NULL`
+	_, err = tx.Exec(tombstoneQuery, time.Now(), userID, messageID)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete message: %w", err)
 	}
 
-	// Delete the message
-	deleteQuery := `DELETE FROM messages WHERE id = ?`
-	_, err = db.Exec(deleteQuery, messageID)
+	// Previous revisions would still reveal the content
+	_, err = tx.Exec(`DELETE FROM message_edits WHERE message_id = ?`, messageID)
 	if err != nil {
-		return fmt.Errorf("failed to delete message: %w", err)
+		return "", fmt.Errorf("failed to delete message edits: %w", err)
+	}
+
+	// Tombstones can't be reacted to
+	_, err = tx.Exec(`DELETE FROM message_reactions WHERE message_id = ?`, messageID)
+	if err != nil {
+		return "", fmt.Errorf("failed to delete message reactions: %w", err)
+	}
+
+	// Drop the media file once no message shows it anymore, so it can't be
+	// downloaded by anyone who kept its URL
+	var orphanedPath string
+	if mediaFileID.Valid {
+		orphanedPath, err = deleteUnusedMediaFile(tx, int(mediaFileID.Int64))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	log.Printf("Message %d deleted by user %d", messageID, userID)
+	return orphanedPath, nil
+}
+
+// deleteUnusedMediaFile deletes a media file's row if no message references it
+// and returns its path, or "" if it is still in use
+func deleteUnusedMediaFile(tx *sql.Tx, mediaFileID int) (string, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM messages WHERE media_file_id = ?`, mediaFileID).Scan(&count)
+	if err != nil {
+		return "", fmt.Errorf("failed to count media file references: %w", err)
+	}
+	if count > 0 {
+		return "", nil
+	}
+
+	var filePath string
+	err = tx.QueryRow(`SELECT file_path FROM media_files WHERE id = ?`, mediaFileID).Scan(&filePath)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get media file: %w", err)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM media_files WHERE id = ?`, mediaFileID); err != nil {
+		return "", fmt.Errorf("failed to delete media file: %w", err)
+	}
+
+	return filePath, nil
+}
+
+// HideMessage deletes a message only for the given user
+func (db *DB) HideMessage(messageID, userID int) error {
+	query := `
+	INSERT OR IGNORE INTO hidden_messages (message_id, user_id, hidden_at)
+	VALUES (?, ?, ?)`
+
+	_, err := db.Exec(query, messageID, userID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to hide message: %w", err)
+	}
+
 	return nil
 }
@@ -0,0 +1,320 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migrations are numbered SQL files, NNNN_name.up.sql with an optional
+// NNNN_name.down.sql to roll them back. They are compiled into the binary.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // Empty if the migration can't be rolled back
+}
+
+// MigrationStatus tells whether a migration has been applied
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+	// Set on the initial migration of a database created before versioned
+	// migrations. It is recorded as applied on the next migration run.
+	Legacy bool
+}
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+// loadMigrations reads the embedded migrations, ordered by version
+func loadMigrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		match := migrationFilePattern.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", file.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, match[2])
+		}
+
+		content, err := migrationFiles.ReadFile("migrations/" + file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", file.Name(), err)
+		}
+
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Migrate applies every pending migration
+func (db *DB) Migrate() error {
+	_, err := db.MigrateUp(0)
+	return err
+}
+
+// MigrateUp applies up to steps pending migrations, or all of them if steps
+// is 0, and returns how many were applied. Each migration runs in its own
+// transaction.
+func (db *DB) MigrateUp(steps int) (int, error) {
+	statuses, err := db.prepareMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			continue
+		}
+		if steps > 0 && applied == steps {
+			break
+		}
+
+		err := db.inTransaction(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(status.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+				status.Version, status.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("failed to apply migration %d_%s: %w", status.Version, status.Name, err)
+		}
+
+		log.Printf("Applied migration %d_%s", status.Version, status.Name)
+		applied++
+	}
+
+	return applied, nil
+}
+
+// MigrateDown rolls back the latest steps applied migrations and returns how
+// many were rolled back
+func (db *DB) MigrateDown(steps int) (int, error) {
+	statuses, err := db.prepareMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	rolledBack := 0
+	for i := len(statuses) - 1; i >= 0 && rolledBack < steps; i-- {
+		status := statuses[i]
+		if status.AppliedAt == nil {
+			continue
+		}
+		if status.Down == "" {
+			return rolledBack, fmt.Errorf("migration %d_%s can't be rolled back", status.Version, status.Name)
+		}
+
+		err := db.inTransaction(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(status.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, status.Version)
+			return err
+		})
+		if err != nil {
+			return rolledBack, fmt.Errorf("failed to roll back migration %d_%s: %w", status.Version, status.Name, err)
+		}
+
+		log.Printf("Rolled back migration %d_%s", status.Version, status.Name)
+		rolledBack++
+	}
+
+	return rolledBack, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied. It
+// only reads the database, so a database without a version table reports
+// every migration as pending.
+func (db *DB) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	return db.migrationStatus(migrations)
+}
+
+// prepareMigrations creates the version table if needed and returns the
+// status of every migration, before applying or rolling back any
+func (db *DB) prepareMigrations() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.ensureMigrationsTable(migrations); err != nil {
+		return nil, err
+	}
+
+	return db.migrationStatus(migrations)
+}
+
+// migrationStatus looks up which of the migrations were applied
+func (db *DB) migrationStatus(migrations []Migration) ([]MigrationStatus, error) {
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, migration := range migrations {
+		statuses[i].Migration = migration
+	}
+
+	migrationsTable, err := db.tableColumns("schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if len(migrationsTable) == 0 {
+		users, err := db.tableColumns("users")
+		if err != nil {
+			return nil, err
+		}
+		if len(users) > 0 && len(statuses) > 0 {
+			statuses[0].Legacy = true
+		}
+		return statuses, nil
+	}
+
+	rows, err := db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		appliedAt[version] = at
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating applied migrations: %w", err)
+	}
+
+	for i := range statuses {
+		if at, ok := appliedAt[statuses[i].Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+
+	return statuses, nil
+}
+
+// ensureMigrationsTable creates the version table. Databases created before
+// versioned migrations have tables but no version table; they are brought up
+// to the initial schema and recorded at its version.
+func (db *DB) ensureMigrationsTable(migrations []Migration) error {
+	migrationsTable, err := db.tableColumns("schema_migrations")
+	if err != nil {
+		return err
+	}
+	if len(migrationsTable) > 0 {
+		return nil
+	}
+
+	users, err := db.tableColumns("users")
+	if err != nil {
+		return err
+	}
+	legacy := len(users) > 0 && len(migrations) > 0
+
+	if legacy {
+		if err := db.addMissingColumns(); err != nil {
+			return err
+		}
+	}
+
+	return db.inTransaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(createMigrationsTable); err != nil {
+			return fmt.Errorf("failed to create migrations table: %w", err)
+		}
+
+		if !legacy {
+			return nil
+		}
+
+		// The initial migration only creates what is missing
+		initial := migrations[0]
+		if _, err := tx.Exec(initial.Up); err != nil {
+			return fmt.Errorf("failed to bring legacy database up to date: %w", err)
+		}
+		_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+			initial.Version, initial.Name, time.Now().UTC())
+		if err != nil {
+			return fmt.Errorf("failed to record legacy database version: %w", err)
+		}
+
+		log.Printf("Recorded existing database at migration %d_%s", initial.Version, initial.Name)
+		return nil
+	})
+}
+
+// inTransaction runs fn in a transaction, committing if it succeeds
+func (db *DB) inTransaction(fn func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+-- Drop every table of the initial schema, dependent tables first
+
+-- The full-text index over messages is created at startup rather than by a
+-- migration, but goes along with the messages table
+DROP TRIGGER IF EXISTS messages_fts_insert;
+DROP TRIGGER IF EXISTS messages_fts_update;
+DROP TRIGGER IF EXISTS messages_fts_delete;
+DROP TABLE IF EXISTS messages_fts;
+
+DROP TABLE IF EXISTS chat_invite_uses;
+DROP TABLE IF EXISTS chat_invites;
+DROP TABLE IF EXISTS message_reactions;
+DROP TABLE IF EXISTS hidden_messages;
+DROP TABLE IF EXISTS message_edits;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS media_files;
+DROP TABLE IF EXISTS chat_members;
+DROP TABLE IF EXISTS chats;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,5 @@
+-- Chat history is paged by message ID within a chat. The composite index
+-- serves those queries and every lookup the chat_id index served.
+
+CREATE INDEX idx_messages_chat_id_id ON messages(chat_id, id);
+DROP INDEX IF EXISTS idx_messages_chat_id;
@@ -0,0 +1,3 @@
+-- Disabled accounts can't log in or use existing tokens
+
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP;
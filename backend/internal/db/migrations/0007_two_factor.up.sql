@@ -0,0 +1,20 @@
+-- Optional TOTP two-factor authentication. The secret is set when the user
+-- starts enrolling and 2FA is on once totp_enabled_at is set. totp_last_step
+-- is the time step of the last accepted code, so a code can't be used twice.
+
+ALTER TABLE users ADD COLUMN totp_secret TEXT;
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER;
+
+-- One-time codes for logging in without the authenticator. Only their hashes
+-- are stored.
+CREATE TABLE recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
@@ -0,0 +1,5 @@
+-- Email addresses are unique regardless of case. This fails if two accounts
+-- already share an address that differs only in case; change one of them and
+-- run the migration again.
+
+CREATE UNIQUE INDEX idx_users_email_nocase ON users(email COLLATE NOCASE);
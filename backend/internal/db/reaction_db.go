@@ -0,0 +1,115 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"OurChat/internal/models"
+)
+
+// AddReaction adds a user's reaction to a message. Adding the same reaction
+// twice has no effect; the result reports whether a reaction was added.
+func (db *DB) AddReaction(messageID, userID int, emoji string) (bool, error) {
+	query := `
+	INSERT OR IGNORE INTO message_reactions (message_id, user_id, emoji, created_at)
+	VALUES (?, ?, ?, ?)`
+
+	result, err := db.Exec(query, messageID, userID, emoji, time.Now())
+	if err != nil {
+		return false, fmt.Errorf("failed to add reaction: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// RemoveReaction removes a user's reaction from a message. The result reports
+// whether the user had reacted with that emoji.
+func (db *DB) RemoveReaction(messageID, userID int, emoji string) (bool, error) {
+	query := `
+	DELETE FROM message_reactions
+	WHERE message_id = ? AND user_id = ? AND emoji = ?`
+
+	result, err := db.Exec(query, messageID, userID, emoji)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove reaction: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// GetReactionSummaries aggregates the reactions of the given messages per
+// emoji, marking the ones the user reacted with. Emojis are ordered by when
+// they were first used on each message.
+func (db *DB) GetReactionSummaries(messageIDs []int, userID int) (map[int][]models.ReactionSummary, error) {
+	summaries := make(map[int][]models.ReactionSummary)
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+
+	// Build placeholders for the IN clause
+	placeholders := make([]string, len(messageIDs))
+	args := make([]interface{}, 0, len(messageIDs)+1)
+	args = append(args, userID)
+	for i, id := range messageIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	query := fmt.Sprintf(`
+	SELECT message_id, emoji, COUNT(*), MAX(user_id = ?)
+	FROM message_reactions
+	WHERE message_id IN (%s)
+	GROUP BY message_id, emoji
+	ORDER BY message_id, MIN(created_at), emoji`, strings.Join(placeholders, ","))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reactions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int
+		var summary models.ReactionSummary
+		if err := rows.Scan(&messageID, &summary.Emoji, &summary.Count, &summary.Reacted); err != nil {
+			return nil, fmt.Errorf("failed to scan reaction: %w", err)
+		}
+		summaries[messageID] = append(summaries[messageID], summary)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating reactions: %w", err)
+	}
+
+	return summaries, nil
+}
+
+// attachReactions fills in the reaction summaries of the messages as seen by the user
+func (db *DB) attachReactions(messages []models.Message, userID int) error {
+	messageIDs := make([]int, len(messages))
+	for i, message := range messages {
+		messageIDs[i] = message.ID
+	}
+
+	summaries, err := db.GetReactionSummaries(messageIDs, userID)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		messages[i].Reactions = summaries[messages[i].ID]
+	}
+
+	return nil
+}
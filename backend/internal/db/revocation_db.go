@@ -0,0 +1,85 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// RevokeToken records that the access token with the given jti must not be
+// accepted anymore. expiresAt is the token's own expiry, after which the
+// record can be dropped.
+func (db *DB) RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	query := `INSERT OR IGNORE INTO revoked_tokens (jti, user_id, expires_at) VALUES (?, ?, ?)`
+	if _, err := db.Exec(query, jti, userID, expiresAt.UTC()); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	return nil
+}
+
+// GetRevokedTokens retrieves the revoked tokens that haven't expired yet, by
+// jti, with their expiry
+func (db *DB) GetRevokedTokens() (map[string]time.Time, error) {
+	rows, err := db.Query(`SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > ?`, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revoked tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make(map[string]time.Time)
+	for rows.Next() {
+		var jti string
+		var expiresAt time.Time
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revoked token: %w", err)
+		}
+		tokens[jti] = expiresAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating revoked tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// GetSessionsRevokedSince retrieves the IDs of sessions revoked after since,
+// with the time they were revoked
+func (db *DB) GetSessionsRevokedSince(since time.Time) (map[int]time.Time, error) {
+	rows, err := db.Query(`SELECT id, revoked_at FROM sessions WHERE revoked_at > ?`, since.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get revoked sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make(map[int]time.Time)
+	for rows.Next() {
+		var id int
+		var revokedAt time.Time
+		if err := rows.Scan(&id, &revokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan revoked session: %w", err)
+		}
+		sessions[id] = revokedAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating revoked sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// DeleteExpiredRevocations removes revoked tokens that have expired anyway
+func (db *DB) DeleteExpiredRevocations() (int64, error) {
+	result, err := db.Exec(`DELETE FROM revoked_tokens WHERE expires_at <= ?`, time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired revocations: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return deleted, nil
+}
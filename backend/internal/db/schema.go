@@ -3,38 +3,109 @@ package db
 import (
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
 )
 
-// LoadSchemaIfNeeded loads the SQL schema file if the tables don't exist yet
+// columnUpgrade describes a column added to a table before versioned
+// migrations existed
+type columnUpgrade struct {
+	Table      string
+	Column     string
+	Definition string
+	Backfill   string // Optional statement run once after the column is added
+}
+
+// columnUpgrades lists the columns added to the first schema before versioned
+// migrations. The initial migration already contains them, but databases from
+// that time may lack some of them. New columns belong in a migration instead.
+var columnUpgrades = []columnUpgrade{
+	{"users", "last_seen_at", "TIMESTAMP", ""},
+	{"users", "hide_last_seen", "BOOLEAN DEFAULT FALSE", ""},
+	{"messages", "edited_at", "TIMESTAMP", ""},
+	{"messages", "deleted_at", "TIMESTAMP", ""},
+	{"messages", "deleted_by", "INTEGER REFERENCES users(id) ON DELETE SET NULL", ""},
+	{"chats", "description", "TEXT", ""},
+	{"chats", "avatar_url", "TEXT", ""},
+	{"messages", "reply_to_message_id", "INTEGER REFERENCES messages(id) ON DELETE SET NULL", ""},
+	// Read cursors start at the last message sent before the member last read the chat
+	{"chat_members", "last_read_message_id", "INTEGER", `
+	UPDATE chat_members
+	SET last_read_message_id = (
+		SELECT MAX(m.id) FROM messages m
+		WHERE m.chat_id = chat_members.chat_id AND m.created_at <= chat_members.last_read_at)
+	WHERE last_read_at IS NOT NULL`},
+}
+
+// LoadSchemaIfNeeded brings the database schema up to date by applying the
+// pending migrations, then sets up the optional full-text index
 func (db *DB) LoadSchemaIfNeeded() error {
-	// Check if users table exists (as a proxy for all tables)
-	var count int
-	err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type='table' AND name='users'").Scan(&count)
-	if err != nil {
-		return fmt.Errorf("failed to check if tables exist: %w", err)
+	if err := db.Migrate(); err != nil {
+		return err
 	}
 
-	// If tables already exist, return early
-	if count > 0 {
-		log.Println("Database schema already exists")
-		return nil
+	// Full-text search is optional, depending on how SQLite was built, so it
+	// is set up outside the migrations
+	if err := db.setupFullTextSearch(); err != nil {
+		return err
 	}
 
-	// Load schema file
-	schemaPath := filepath.Join("internal", "db", "migrations", "schema.sql")
-	schemaSQL, err := os.ReadFile(schemaPath)
-	if err != nil {
-		return fmt.Errorf("failed to read schema file: %w", err)
+	log.Println("Database schema loaded successfully")
+	return nil
+}
+
+// addMissingColumns applies columnUpgrades to the tables of a database that
+// predates versioned migrations
+func (db *DB) addMissingColumns() error {
+	for _, upgrade := range columnUpgrades {
+		columns, err := db.tableColumns(upgrade.Table)
+		if err != nil {
+			return err
+		}
+
+		// Missing tables are created with all their columns by the initial migration
+		if len(columns) == 0 || columns[upgrade.Column] {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", upgrade.Table, upgrade.Column, upgrade.Definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", upgrade.Table, upgrade.Column, err)
+		}
+
+		if upgrade.Backfill != "" {
+			if _, err := db.Exec(upgrade.Backfill); err != nil {
+				return fmt.Errorf("failed to backfill column %s.%s: %w", upgrade.Table, upgrade.Column, err)
+			}
+		}
+
+		log.Printf("Added column %s.%s", upgrade.Table, upgrade.Column)
 	}
 
-	// Execute schema SQL
-	_, err = db.Exec(string(schemaSQL))
+	return nil
+}
+
+// tableColumns returns the set of column names of a table, or an empty set if
+// the table doesn't exist
+func (db *DB) tableColumns(table string) (map[string]bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		return fmt.Errorf("failed to execute schema SQL: %w", err)
+		return nil, fmt.Errorf("failed to get columns of %s: %w", table, err)
 	}
+	defer rows.Close()
 
-	log.Println("Database schema loaded successfully")
-	return nil
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, fmt.Errorf("failed to scan column of %s: %w", table, err)
+		}
+		columns[name] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating columns of %s: %w", table, err)
+	}
+
+	return columns, nil
 }
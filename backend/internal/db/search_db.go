@@ -0,0 +1,362 @@
+package db
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+
+	"OurChat/internal/models"
+)
+
+// fullTextSchema creates the FTS5 index over message content and media file
+// names. The index uses message IDs as row IDs and triggers keep it in sync.
+const fullTextSchema = `
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+    content,
+    media_filename,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts (rowid, content, media_filename)
+    VALUES (new.id, new.content,
+        COALESCE((SELECT original_filename FROM media_files WHERE id = new.media_file_id), ''));
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF content, media_file_id ON messages BEGIN
+    DELETE FROM messages_fts WHERE rowid = old.id;
+    INSERT INTO messages_fts (rowid, content, media_filename)
+    VALUES (new.id, new.content,
+        COALESCE((SELECT original_filename FROM media_files WHERE id = new.media_file_id), ''));
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+    DELETE FROM messages_fts WHERE rowid = old.id;
+END;`
+
+// fullTextRebuild reindexes every message. It runs whenever the sync
+// triggers are missing, which happens the first time the index is created and
+// after running on a SQLite build without FTS5 (see dropFullTextTriggers).
+const fullTextRebuild = `
+DELETE FROM messages_fts;
+
+INSERT INTO messages_fts (rowid, content, media_filename)
+SELECT m.id, m.content, COALESCE(mf.original_filename, '')
+FROM messages m
+LEFT JOIN media_files mf ON m.media_file_id = mf.id;`
+
+// dropFullTextTriggers removes the sync triggers, which would make every
+// write to messages fail on a SQLite build without FTS5
+const dropFullTextTriggers = `
+DROP TRIGGER IF EXISTS messages_fts_insert;
+DROP TRIGGER IF EXISTS messages_fts_update;
+DROP TRIGGER IF EXISTS messages_fts_delete;`
+
+// Number of tokens around the matches in a search snippet
+const snippetTokens = 12
+
+// Maximum length of a snippet built without FTS5
+const fallbackSnippetLength = 120
+
+// Control characters that mark matches in a raw snippet. They are swapped for
+// <mark> tags only after the message text is HTML-escaped.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// snippetReplacer turns the match delimiters of an escaped snippet into tags
+var snippetReplacer = strings.NewReplacer(matchStart, "<mark>", matchEnd, "</mark>")
+
+// formatSnippet HTML-escapes a raw snippet and wraps its matches in <mark>
+// tags, so it is safe to render as HTML
+func formatSnippet(raw string) string {
+	return snippetReplacer.Replace(html.EscapeString(raw))
+}
+
+// setupFullTextSearch creates the full-text index if SQLite supports FTS5
+func (db *DB) setupFullTextSearch() error {
+	var available bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&available); err != nil {
+		return fmt.Errorf("failed to check for FTS5 support: %w", err)
+	}
+
+	if !available {
+		if _, err := db.Exec(dropFullTextTriggers); err != nil {
+			return fmt.Errorf("failed to drop full-text triggers: %w", err)
+		}
+		log.Println("SQLite was built without FTS5, message search will use LIKE scans")
+		return nil
+	}
+
+	var triggers int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'messages_fts_insert'`).Scan(&triggers)
+	if err != nil {
+		return fmt.Errorf("failed to check full-text triggers: %w", err)
+	}
+
+	if _, err := db.Exec(fullTextSchema); err != nil {
+		return fmt.Errorf("failed to create full-text index: %w", err)
+	}
+
+	// Messages written without the triggers aren't in the index
+	if triggers == 0 {
+		if _, err := db.Exec(fullTextRebuild); err != nil {
+			return fmt.Errorf("failed to build full-text index: %w", err)
+		}
+		log.Println("Built full-text index for existing messages")
+	}
+
+	db.fullTextSearch = true
+	return nil
+}
+
+// SearchOptions restricts and pages a message search
+type SearchOptions struct {
+	ChatID int // Only search this chat if set, otherwise every chat of the user
+	Limit  int
+	Offset int
+
+	// Filters, ignored when empty
+	SenderID      int
+	Since         *time.Time // Sent at or after
+	Until         *time.Time // Sent before
+	MessageType   string     // "text" or "media"
+	MediaCategory string     // "image", "video", "audio" or "document"
+	HasLink       bool
+}
+
+// Media MIME categories that can be searched for. Documents are every other
+// kind of file.
+var mediaCategories = map[string]string{
+	"image": "image/%",
+	"video": "video/%",
+	"audio": "audio/%",
+}
+
+// IsValidMediaCategory checks whether media can be filtered by the category
+func IsValidMediaCategory(category string) bool {
+	_, ok := mediaCategories[category]
+	return ok || category == "document"
+}
+
+// SearchMessages searches the messages visible to a user. The search text can
+// be empty if the options contain a filter. With FTS5 text matches are ranked
+// by relevance, otherwise the newest matches come first.
+// Deleted messages are never returned.
+func (db *DB) SearchMessages(userID int, searchText string, options SearchOptions) ([]models.MessageSearchResult, error) {
+	// The name of a direct chat is the other member's username
+	chatContextColumns := `,
+	c.id, c.type,
+	CASE WHEN c.type = 'direct' THEN COALESCE((
+		SELECT u.username FROM chat_members ocm JOIN users u ON ocm.user_id = u.id
+		WHERE ocm.chat_id = c.id AND ocm.user_id != cm.user_id), '')
+	ELSE COALESCE(c.name, '') END`
+
+	conditions := []string{"m.deleted_at IS NULL", notHiddenForUser}
+	args := []interface{}{userID}
+
+	if options.ChatID != 0 {
+		conditions = append(conditions, "m.chat_id = ?")
+		args = append(args, options.ChatID)
+	}
+	if options.SenderID != 0 {
+		conditions = append(conditions, "m.sender_id = ?")
+		args = append(args, options.SenderID)
+	}
+	// Stored timestamps may carry different offsets, so compare them as dates
+	if options.Since != nil {
+		conditions = append(conditions, "julianday(m.created_at) >= julianday(?)")
+		args = append(args, options.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if options.Until != nil {
+		conditions = append(conditions, "julianday(m.created_at) < julianday(?)")
+		args = append(args, options.Until.UTC().Format(time.RFC3339Nano))
+	}
+	if options.MessageType != "" {
+		conditions = append(conditions, "m.message_type = ?")
+		args = append(args, options.MessageType)
+	}
+	if options.MediaCategory != "" {
+		if pattern, ok := mediaCategories[options.MediaCategory]; ok {
+			conditions = append(conditions, "mf.mime_type LIKE ?")
+			args = append(args, pattern)
+		} else {
+			conditions = append(conditions, "mf.id IS NOT NULL AND mf.mime_type NOT LIKE 'image/%' AND mf.mime_type NOT LIKE 'video/%' AND mf.mime_type NOT LIKE 'audio/%'")
+		}
+	}
+	if options.HasLink {
+		conditions = append(conditions, "(m.content LIKE '%http://%' OR m.content LIKE '%https://%' OR m.content LIKE '%www.%')")
+	}
+
+	var query string
+	var queryArgs []interface{}
+	useFullText := db.fullTextSearch && searchText != ""
+
+	if useFullText {
+		matchQuery := buildMatchQuery(searchText)
+		if matchQuery == "" {
+			return make([]models.MessageSearchResult, 0), nil
+		}
+
+		query = `
+	SELECT` + messageWithMediaColumns + `,
+	snippet(messages_fts, -1, char(2), char(3), '…', ` + fmt.Sprint(snippetTokens) + `)` + chatContextColumns + `
+	FROM messages_fts
+	JOIN messages m ON m.id = messages_fts.rowid
+	JOIN chats c ON m.chat_id = c.id
+	JOIN chat_members cm ON cm.chat_id = m.chat_id AND cm.user_id = ?` + messageWithMediaJoins + `
+	WHERE messages_fts MATCH ? AND ` + strings.Join(conditions, " AND ") + `
+	ORDER BY messages_fts.rank, m.id DESC
+	LIMIT ? OFFSET ?`
+		queryArgs = append([]interface{}{userID, matchQuery}, args...)
+	} else {
+		if searchText != "" {
+			pattern := "%" + escapeLike(searchText) + "%"
+			conditions = append(conditions, `(m.content LIKE ? ESCAPE '\' OR mf.original_filename LIKE ? ESCAPE '\')`)
+			args = append(args, pattern, pattern)
+		}
+
+		query = `
+	SELECT` + messageWithMediaColumns + `,
+	m.content` + chatContextColumns + `
+	FROM messages m
+	JOIN chats c ON m.chat_id = c.id
+	JOIN chat_members cm ON cm.chat_id = m.chat_id AND cm.user_id = ?` + messageWithMediaJoins + `
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY m.created_at DESC, m.id DESC
+	LIMIT ? OFFSET ?`
+		queryArgs = append([]interface{}{userID}, args...)
+	}
+	queryArgs = append(queryArgs, options.Limit, options.Offset)
+
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	results := make([]models.MessageSearchResult, 0)
+	messages := make([]models.Message, 0)
+	for rows.Next() {
+		var result models.MessageSearchResult
+		chat := &models.ChatContext{}
+
+		message, err := scanMessageWithMedia(extraColumns{
+			row:   rows,
+			extra: []interface{}{&result.Snippet, &chat.ID, &chat.Type, &chat.Name},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+
+		// Without FTS5 the snippet column is the whole content
+		if !useFullText {
+			result.Snippet = highlightMatch(result.Snippet, searchText)
+		}
+		result.Snippet = formatSnippet(result.Snippet)
+
+		result.Chat = chat
+		results = append(results, result)
+		messages = append(messages, *message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search results: %w", err)
+	}
+
+	if err := db.applyReadState(messages, userID); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Message = messages[i]
+	}
+
+	log.Printf("Found %d messages matching '%s' for user %d", len(results), searchText, userID)
+	return results, nil
+}
+
+// extraColumns scans additional columns selected after the ones a scan
+// function knows about
+type extraColumns struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (e extraColumns) Scan(dest ...interface{}) error {
+	return e.row.Scan(append(dest, e.extra...)...)
+}
+
+// buildMatchQuery turns free text into an FTS5 query that matches messages
+// containing every word, treating the last word as a prefix. Words are quoted
+// so FTS5 operators in the input are taken literally.
+func buildMatchQuery(searchText string) string {
+	words := strings.FieldsFunc(searchText, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return ""
+	}
+
+	terms := make([]string, len(words))
+	for i, word := range words {
+		terms[i] = `"` + word + `"`
+	}
+	terms[len(terms)-1] += "*"
+
+	return strings.Join(terms, " ")
+}
+
+// escapeLike escapes the LIKE wildcards in user input
+func escapeLike(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(text)
+}
+
+// highlightMatch builds a raw snippet like FTS5's for a LIKE match: an excerpt
+// around the first case-insensitive occurrence of the search text
+func highlightMatch(content, searchText string) string {
+	lowerContent := []rune(strings.ToLower(content))
+	runes := []rune(content)
+	needle := []rune(strings.ToLower(searchText))
+
+	// Lowercasing can change lengths for some scripts; don't highlight then
+	index := -1
+	if len(needle) > 0 && len(lowerContent) == len(runes) {
+		index = strings.Index(string(lowerContent), string(needle))
+	}
+	if index < 0 {
+		return truncateContent(content, fallbackSnippetLength)
+	}
+
+	// Convert the byte index into a rune index
+	start := len([]rune(string(lowerContent)[:index]))
+	end := start + len(needle)
+
+	from := start - fallbackSnippetLength/2
+	if from < 0 {
+		from = 0
+	}
+	to := end + fallbackSnippetLength/2
+	if to > len(runes) {
+		to = len(runes)
+	}
+
+	var snippet strings.Builder
+	if from > 0 {
+		snippet.WriteString("…")
+	}
+	snippet.WriteString(string(runes[from:start]))
+	snippet.WriteString(matchStart)
+	snippet.WriteString(string(runes[start:end]))
+	snippet.WriteString(matchEnd)
+	snippet.WriteString(string(runes[end:to]))
+	if to < len(runes) {
+		snippet.WriteString("…")
+	}
+
+	return snippet.String()
+}
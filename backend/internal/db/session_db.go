@@ -0,0 +1,277 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"OurChat/internal/models"
+)
+
+// generateSecretToken creates a random token for refresh tokens and emailed
+// links. Only its hash is stored, so a leaked database doesn't leak usable
+// tokens.
+func generateSecretToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// hashSecretToken returns the stored form of a secret token
+func hashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession starts a session for a user on a device and returns it with
+// its first refresh token. The session expires if the token isn't used within
+// lifetime.
+func (db *DB) CreateSession(userID int, deviceName, ipAddress, userAgent string, lifetime time.Duration) (*models.Session, string, error) {
+	token, err := generateSecretToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Timestamps are compared as text in SQLite, so they are stored in UTC
+	now := time.Now().UTC()
+
+	query := `
+	INSERT INTO sessions (user_id, refresh_token_hash, device_name, ip_address, user_agent, created_at, last_used_at, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	result, err := db.Exec(query, userID, hashSecretToken(token), deviceName, ipAddress, userAgent,
+		now, now, now.Add(lifetime))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create session: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+
+	session, err := db.getSession(`WHERE id = ?`, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	log.Printf("Session %d created for user %d", session.ID, userID)
+	return session, token, nil
+}
+
+// ReusedRefreshTokenError is returned by RotateSession when a refresh token
+// that was already exchanged is presented again
+type ReusedRefreshTokenError struct {
+	// The session the token belonged to, which is now revoked
+	SessionID int
+}
+
+func (e *ReusedRefreshTokenError) Error() string {
+	return fmt.Sprintf("refresh token was reused, session %d revoked", e.SessionID)
+}
+
+// RotateSession exchanges a refresh token for a new one, extending the
+// session by lifetime. Presenting a token that was already exchanged means it
+// was copied, so the session it belongs to is revoked and a
+// *ReusedRefreshTokenError is returned.
+func (db *DB) RotateSession(token, ipAddress, userAgent string, lifetime time.Duration) (*models.Session, string, error) {
+	newToken, err := generateSecretToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	hash := hashSecretToken(token)
+
+	query := `
+	UPDATE sessions
+	SET refresh_token_hash = ?, previous_token_hash = refresh_token_hash,
+	    ip_address = ?, user_agent = ?, last_used_at = ?, expires_at = ?
+	WHERE refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?`
+
+	result, err := tx.Exec(query, hashSecretToken(newToken), ipAddress, userAgent, now, now.Add(lifetime), hash, now)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to rotate session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		// Sessions that were already revoked are kept until they expire, so
+		// reuse is detected for them as well
+		var sessionID int
+		err := tx.QueryRow(`SELECT id FROM sessions WHERE previous_token_hash = ? AND expires_at > ?`, hash, now).Scan(&sessionID)
+		if err == sql.ErrNoRows {
+			return nil, "", fmt.Errorf("refresh token is invalid or expired")
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to check for reused refresh token: %w", err)
+		}
+
+		if _, err := tx.Exec(`UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, now, sessionID); err != nil {
+			return nil, "", fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, "", &ReusedRefreshTokenError{SessionID: sessionID}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	session, err := db.getSession(`WHERE refresh_token_hash = ?`, hashSecretToken(newToken))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return session, newToken, nil
+}
+
+// getSession retrieves a single session matching the given condition
+func (db *DB) getSession(condition string, args ...interface{}) (*models.Session, error) {
+	query := `
+	SELECT id, user_id, device_name, ip_address, user_agent, created_at, last_used_at, expires_at
+	FROM sessions ` + condition
+
+	session, err := scanSession(db.QueryRow(query, args...))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("session not found")
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return session, nil
+}
+
+// scanSession scans a single session row
+func scanSession(row rowScanner) (*models.Session, error) {
+	session := &models.Session{}
+	var deviceName, ipAddress, userAgent sql.NullString
+
+	err := row.Scan(
+		&session.ID, &session.UserID, &deviceName, &ipAddress, &userAgent,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session.DeviceName = deviceName.String
+	session.IPAddress = ipAddress.String
+	session.UserAgent = userAgent.String
+
+	return session, nil
+}
+
+// GetUserSessions retrieves the active sessions of a user, most recently
+// used first
+func (db *DB) GetUserSessions(userID int) ([]models.Session, error) {
+	query := `
+	SELECT id, user_id, device_name, ip_address, user_agent, created_at, last_used_at, expires_at
+	FROM sessions
+	WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	ORDER BY last_used_at DESC, id DESC`
+
+	rows, err := db.Query(query, userID, time.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]models.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, *session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes one of a user's sessions so its refresh token stops
+// working
+func (db *DB) RevokeSession(sessionID, userID int) error {
+	query := `
+	UPDATE sessions SET revoked_at = ?
+	WHERE id = ? AND user_id = ? AND revoked_at IS NULL`
+
+	result, err := db.Exec(query, time.Now().UTC(), sessionID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("session not found or already revoked")
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes every session of a user
+func (db *DB) RevokeUserSessions(userID int) error {
+	if err := revokeUserSessions(db, userID); err != nil {
+		return err
+	}
+
+	log.Printf("Revoked all sessions of user %d", userID)
+	return nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// revokeUserSessions revokes every session of a user, inside or outside a
+// transaction
+func revokeUserSessions(exec execer, userID int) error {
+	query := `UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL`
+	if _, err := exec.Exec(query, time.Now().UTC(), userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteStaleSessions removes a user's expired sessions. Revoked sessions are
+// kept until their refresh tokens expire too, so presenting one of them is
+// still recognized instead of looking like an unknown token.
+func (db *DB) DeleteStaleSessions(userID int) error {
+	query := `DELETE FROM sessions WHERE user_id = ? AND expires_at <= ?`
+	if _, err := db.Exec(query, userID, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to delete stale sessions: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRotateSession(t *testing.T) {
+	database := newTestDB(t)
+	userID := createTestUser(t, database, "alice")
+
+	session, firstToken, err := database.CreateSession(userID, "laptop", "127.0.0.1", "test", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	rotated, secondToken, err := database.RotateSession(firstToken, "127.0.0.1", "test", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to rotate session: %v", err)
+	}
+	if rotated.ID != session.ID {
+		t.Errorf("rotated session ID = %d, want %d", rotated.ID, session.ID)
+	}
+	if secondToken == firstToken {
+		t.Error("rotation returned the same refresh token")
+	}
+
+	// Each case runs after the ones before it
+	tests := []struct {
+		name      string
+		token     string
+		wantReuse bool
+	}{
+		{"first token again", firstToken, true},
+		{"current token after reuse", secondToken, false},
+		{"first token after revocation", firstToken, true},
+		{"unknown token", "not-a-refresh-token", false},
+	}
+
+	for _, tt := range tests {
+		_, _, err := database.RotateSession(tt.token, "127.0.0.1", "test", time.Hour)
+		if err == nil {
+			t.Errorf("%s: RotateSession succeeded", tt.name)
+			continue
+		}
+
+		var reused *ReusedRefreshTokenError
+		if errors.As(err, &reused) != tt.wantReuse {
+			t.Errorf("%s: RotateSession error = %v, want reuse %v", tt.name, err, tt.wantReuse)
+		} else if tt.wantReuse && reused.SessionID != session.ID {
+			t.Errorf("%s: reused session ID = %d, want %d", tt.name, reused.SessionID, session.ID)
+		}
+	}
+}
+
+func TestRotateExpiredSession(t *testing.T) {
+	database := newTestDB(t)
+	userID := createTestUser(t, database, "alice")
+
+	_, token, err := database.CreateSession(userID, "laptop", "127.0.0.1", "test", -time.Minute)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	if _, _, err := database.RotateSession(token, "127.0.0.1", "test", time.Hour); err == nil {
+		t.Error("RotateSession succeeded for an expired session")
+	}
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Number of recovery codes a user gets when enabling 2FA
+const recoveryCodeCount = 10
+
+// generateRecoveryCode creates a random recovery code like "k3vq7-m2xpa"
+func generateRecoveryCode() (string, error) {
+	bytes := make([]byte, 7)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces in a typed code
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// SetTOTPSecret stores the secret of a user who started enrolling in 2FA.
+// It replaces the secret of an earlier enrollment that wasn't confirmed.
+func (db *DB) SetTOTPSecret(userID int, secret string) error {
+	query := `UPDATE users SET totp_secret = ?, totp_last_step = NULL WHERE id = ? AND totp_enabled_at IS NULL`
+
+	result, err := db.Exec(query, secret, userID)
+	if err != nil {
+		return fmt.Errorf("failed to set TOTP secret: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	return nil
+}
+
+// EnableTOTP turns on 2FA for a user whose enrollment was confirmed with the
+// code of the given time step, and returns their new recovery codes
+func (db *DB) EnableTOTP(userID int, step int64) ([]string, error) {
+	var codes []string
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		query := `
+		UPDATE users SET totp_enabled_at = ?, totp_last_step = ?
+		WHERE id = ? AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL`
+
+		result, err := tx.Exec(query, time.Now().UTC(), step, userID)
+		if err != nil {
+			return fmt.Errorf("failed to enable TOTP: %w", err)
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+
+		if rowsAffected == 0 {
+			return fmt.Errorf("no two-factor enrollment in progress")
+		}
+
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Two-factor authentication enabled for user %d", userID)
+	return codes, nil
+}
+
+// DisableTOTP turns off 2FA for a user and deletes their recovery codes
+func (db *DB) DisableTOTP(userID int) error {
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = ?`
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("failed to disable TOTP: %w", err)
+		}
+
+		if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Two-factor authentication disabled for user %d", userID)
+	return nil
+}
+
+// UseTOTPStep records that a user logged in with the code of a time step.
+// It fails if a code of that step or a later one was already used, so a code
+// seen by someone else can't be replayed.
+func (db *DB) UseTOTPStep(userID int, step int64) error {
+	query := `
+	UPDATE users SET totp_last_step = ?
+	WHERE id = ? AND totp_enabled_at IS NOT NULL AND (totp_last_step IS NULL OR totp_last_step < ?)`
+
+	result, err := db.Exec(query, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to use TOTP code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("code was already used")
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks one of a user's unused recovery codes as used
+func (db *DB) UseRecoveryCode(userID int, code string) error {
+	query := `
+	UPDATE recovery_codes SET used_at = ?
+	WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`
+
+	result, err := db.Exec(query, time.Now().UTC(), userID, hashSecretToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("recovery code is invalid or already used")
+	}
+
+	log.Printf("Recovery code used by user %d", userID)
+	return nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (db *DB) CountRecoveryCodes(userID int) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL`
+	if err := db.QueryRow(query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+
+	return count, nil
+}
+
+// RegenerateRecoveryCodes replaces all of a user's recovery codes with new
+// ones and returns them
+func (db *DB) RegenerateRecoveryCodes(userID int) ([]string, error) {
+	var codes []string
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		var err error
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and creates new ones
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	now := time.Now().UTC()
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		query := `INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)`
+		if _, err := tx.Exec(query, userID, hashSecretToken(normalizeRecoveryCode(code)), now); err != nil {
+			return nil, fmt.Errorf("failed to create recovery code: %w", err)
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+// enableTestTOTP turns on 2FA for a user as if they confirmed enrollment with
+// the code of step, and returns their recovery codes
+func enableTestTOTP(t *testing.T, database *DB, userID int, step int64) []string {
+	t.Helper()
+
+	if err := database.SetTOTPSecret(userID, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatalf("failed to set TOTP secret: %v", err)
+	}
+
+	codes, err := database.EnableTOTP(userID, step)
+	if err != nil {
+		t.Fatalf("failed to enable TOTP: %v", err)
+	}
+
+	return codes
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	database := newTestDB(t)
+	userID := createTestUser(t, database, "alice")
+
+	// Enrolling used the code of step 100
+	enableTestTOTP(t, database, userID, 100)
+
+	// Each case runs after the ones before it
+	tests := []struct {
+		name   string
+		step   int64
+		wantOK bool
+	}{
+		{"enrollment step", 100, false},
+		{"earlier step", 99, false},
+		{"next step", 101, true},
+		{"same step again", 101, false},
+		{"skipped ahead", 105, true},
+		{"step before last use", 103, false},
+	}
+
+	for _, tt := range tests {
+		err := database.UseTOTPStep(userID, tt.step)
+		if (err == nil) != tt.wantOK {
+			t.Errorf("%s: UseTOTPStep(%d) error = %v, want ok %v", tt.name, tt.step, err, tt.wantOK)
+		}
+	}
+}
+
+func TestUseTOTPStepWithoutTOTP(t *testing.T) {
+	database := newTestDB(t)
+	userID := createTestUser(t, database, "alice")
+
+	if err := database.UseTOTPStep(userID, 100); err == nil {
+		t.Error("UseTOTPStep succeeded for a user without 2FA")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"k3vq7-m2xpa", "k3vq7m2xpa"},
+		{"K3VQ7-M2XPA", "k3vq7m2xpa"},
+		{"k3vq7m2xpa", "k3vq7m2xpa"},
+		{"k3vq7 m2xpa", "k3vq7m2xpa"},
+		{" k3vq7 - m2xpa ", "k3vq7m2xpa"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.code); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	database := newTestDB(t)
+	userID := createTestUser(t, database, "alice")
+	otherID := createTestUser(t, database, "bob")
+
+	codes := enableTestTOTP(t, database, userID, 100)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	// Each case runs after the ones before it
+	tests := []struct {
+		name   string
+		userID int
+		code   string
+		wantOK bool
+	}{
+		{"as generated", userID, codes[0], true},
+		{"used twice", userID, codes[0], false},
+		{"typed differently", userID, strings.ToUpper(strings.ReplaceAll(codes[1], "-", " ")), true},
+		{"other user's code", otherID, codes[2], false},
+		{"unknown code", userID, "aaaaa-bbbbb", false},
+	}
+
+	for _, tt := range tests {
+		err := database.UseRecoveryCode(tt.userID, tt.code)
+		if (err == nil) != tt.wantOK {
+			t.Errorf("%s: UseRecoveryCode(%q) error = %v, want ok %v", tt.name, tt.code, err, tt.wantOK)
+		}
+	}
+
+	remaining, err := database.CountRecoveryCodes(userID)
+	if err != nil {
+		t.Fatalf("failed to count recovery codes: %v", err)
+	}
+	if remaining != recoveryCodeCount-2 {
+		t.Errorf("%d recovery codes left, want %d", remaining, recoveryCodeCount-2)
+	}
+}
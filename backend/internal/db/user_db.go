@@ -33,14 +33,17 @@ func (db *DB) CreateUser(username, email, password string) error {
 // GetUserByUsername retrieves a user by their username
 func (db *DB) GetUserByUsername(username string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, email, password, jwt_key, created_at, last_login, status
+	query := `SELECT id, username, email, password, jwt_key, created_at, last_login, status, disabled_at,
+	                 totp_secret, totp_enabled_at
 	          FROM users WHERE username = ?`
 
-	var lastLogin sql.NullTime
+	var lastLogin, disabledAt, totpEnabledAt sql.NullTime
+	var totpSecret sql.NullString
 
 	err := db.QueryRow(query, username).Scan(
 		&user.ID, &user.Username, &user.Email, &user.Password,
-		&user.JWTKey, &user.CreatedAt, &lastLogin, &user.Status,
+		&user.JWTKey, &user.CreatedAt, &lastLogin, &user.Status, &disabledAt,
+		&totpSecret, &totpEnabledAt,
 	)
 
 	if err != nil {
@@ -54,15 +57,24 @@ func (db *DB) GetUserByUsername(username string) (*models.User, error) {
 		user.LastLogin = &lastLogin.Time
 	}
 
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
+
+	user.TOTPSecret = totpSecret.String
+	if totpEnabledAt.Valid {
+		user.TOTPEnabledAt = &totpEnabledAt.Time
+	}
+
 	log.Println("User retrieved successfully")
 	return user, nil
 }
 
-// GetUserByEmail retrieves a user by their email address
+// GetUserByEmail retrieves a user by their email address, ignoring case
 func (db *DB) GetUserByEmail(email string) (*models.User, error) {
 	user := &models.User{}
 	query := `SELECT id, username, email, password, jwt_key, created_at, last_login, status
-	          FROM users WHERE email = ?`
+	          FROM users WHERE email = ? COLLATE NOCASE`
 
 	var lastLogin sql.NullTime
 
@@ -98,6 +110,16 @@ func (db *DB) UpdateUserStatus(userID int, status string) error {
 	return nil
 }
 
+// UpdateLastSeen stores the time a user was last active
+func (db *DB) UpdateLastSeen(userID int, lastSeen time.Time) error {
+	query := `UPDATE users SET last_seen_at = ? WHERE id = ?`
+	_, err := db.Exec(query, lastSeen, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update last seen: %w", err)
+	}
+	return nil
+}
+
 // UpdateUserProfile updates a user's profile information
 func (db *DB) UpdateUserProfile(userID int, updates map[string]interface{}) error {
 	// Start a transaction
@@ -116,13 +138,13 @@ func (db *DB) UpdateUserProfile(userID int, updates map[string]interface{}) erro
 		case "email":
 			// Check if email is already in use by another user
 			var count int
-			checkQuery := "SELECT COUNT(*) FROM users WHERE email = ? AND id != ?"
+			checkQuery := "SELECT COUNT(*) FROM users WHERE email = ? COLLATE NOCASE AND id != ?"
 			err := db.QueryRow(checkQuery, value, userID).Scan(&count)
 			if err != nil {
 				return fmt.Errorf("failed to check email uniqueness: %w", err)
 			}
 			if count > 0 {
-				return fmt.Errorf("email is already in use")
+				return ErrEmailInUse
 			}
 
 			query = "UPDATE users SET email = ? WHERE id = ?"
@@ -132,6 +154,10 @@ func (db *DB) UpdateUserProfile(userID int, updates map[string]interface{}) erro
 			query = "UPDATE users SET status = ? WHERE id = ?"
 			args = []interface{}{value, userID}
 
+		case "hide_last_seen":
+			query = "UPDATE users SET hide_last_seen = ? WHERE id = ?"
+			args = []interface{}{value, userID}
+
 		default:
 			// Skip unsupported fields
 			continue
@@ -163,7 +189,7 @@ func (db *DB) GetUsersByIDs(userIDs []int) (map[int]models.UserBasic, error) {
 	placeholders = placeholders[:len(placeholders)-1] // Remove trailing comma
 
 	query := fmt.Sprintf(`
-    SELECT id, username, status, profile_picture_url
+    SELECT id, username, status, profile_picture_url, last_seen_at, hide_last_seen
     FROM users
     WHERE id IN (%s)`, placeholders)
 
@@ -182,9 +208,14 @@ func (db *DB) GetUsersByIDs(userIDs []int) (map[int]models.UserBasic, error) {
 	users := make(map[int]models.UserBasic)
 	for rows.Next() {
 		var user models.UserBasic
-		if err := rows.Scan(&user.ID, &user.Username, &user.Status, &user.ProfilePictureURL); err != nil {
+		var lastSeenAt sql.NullTime
+		if err := rows.Scan(&user.ID, &user.Username, &user.Status, &user.ProfilePictureURL,
+			&lastSeenAt, &user.HideLastSeen); err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
+		if lastSeenAt.Valid {
+			user.LastSeenAt = &lastSeenAt.Time
+		}
 		users[user.ID] = user
 	}
 
@@ -207,7 +238,7 @@ func (db *DB) SearchUsersByName(searchTerm string, limit int, excludeUserID int)
 	searchPattern := "%" + searchTerm + "%"
 
 	query := `
-    SELECT id, username, status, profile_picture_url
+    SELECT id, username, status, profile_picture_url, last_seen_at, hide_last_seen
     FROM users
     WHERE username LIKE ? AND id != ?
     ORDER BY
@@ -235,9 +266,14 @@ func (db *DB) SearchUsersByName(searchTerm string, limit int, excludeUserID int)
 	users := make([]models.UserBasic, 0)
 	for rows.Next() {
 		var user models.UserBasic
-		if err := rows.Scan(&user.ID, &user.Username, &user.Status, &user.ProfilePictureURL); err != nil {
+		var lastSeenAt sql.NullTime
+		if err := rows.Scan(&user.ID, &user.Username, &user.Status, &user.ProfilePictureURL,
+			&lastSeenAt, &user.HideLastSeen); err != nil {
 			return nil, fmt.Errorf("failed to scan user: %w", err)
 		}
+		if lastSeenAt.Valid {
+			user.LastSeenAt = &lastSeenAt.Time
+		}
 		users = append(users, user)
 	}
 
@@ -0,0 +1,131 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ErrEmailInUse is returned when an address already belongs to another user
+var ErrEmailInUse = errors.New("email is already in use")
+
+// CreateEmailVerification starts verification of an email address for a user
+// and returns the token to email to it. The address is either the user's
+// current one or a new one that replaces it once verified. Only the latest
+// verification of a user stays valid.
+func (db *DB) CreateEmailVerification(userID int, email string, lifetime time.Duration) (string, error) {
+	token, err := generateSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	// Timestamps are compared as text in SQLite, so they are stored in UTC
+	now := time.Now().UTC()
+
+	err = db.inTransaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM email_verifications WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to delete previous verifications: %w", err)
+		}
+
+		query := `
+		INSERT INTO email_verifications (user_id, email, token_hash, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`
+
+		if _, err := tx.Exec(query, userID, email, hashSecretToken(token), now, now.Add(lifetime)); err != nil {
+			return fmt.Errorf("failed to create email verification: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// VerifyEmail marks the address a verification token was sent to as verified
+// and makes it the user's email. It returns the user's ID and the verified
+// address. The token can only be used once. If another user took the address
+// in the meantime, ErrEmailInUse is returned.
+func (db *DB) VerifyEmail(token string) (int, string, error) {
+	var userID int
+	var email string
+
+	err := db.inTransaction(func(tx *sql.Tx) error {
+		query := `
+		SELECT user_id, email FROM email_verifications
+		WHERE token_hash = ? AND expires_at > ?`
+
+		err := tx.QueryRow(query, hashSecretToken(token), time.Now().UTC()).Scan(&userID, &email)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("verification token is invalid or expired")
+			}
+			return fmt.Errorf("failed to get email verification: %w", err)
+		}
+
+		// Someone else may have taken the address since the token was sent
+		var count int
+		checkQuery := "SELECT COUNT(*) FROM users WHERE email = ? COLLATE NOCASE AND id != ?"
+		if err := tx.QueryRow(checkQuery, email, userID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to check email uniqueness: %w", err)
+		}
+		if count > 0 {
+			return ErrEmailInUse
+		}
+
+		updateQuery := `UPDATE users SET email = ?, email_verified_at = ? WHERE id = ?`
+		if _, err := tx.Exec(updateQuery, email, time.Now().UTC(), userID); err != nil {
+			return fmt.Errorf("failed to verify email: %w", err)
+		}
+
+		if _, err := tx.Exec(`DELETE FROM email_verifications WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to delete verifications: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	log.Printf("Email verified for user %d", userID)
+	return userID, email, nil
+}
+
+// GetPendingEmail retrieves the new address a user asked to change to and
+// hasn't verified yet, or an empty string if there is none
+func (db *DB) GetPendingEmail(userID int) (string, error) {
+	query := `
+	SELECT v.email FROM email_verifications v
+	JOIN users u ON u.id = v.user_id
+	WHERE v.user_id = ? AND v.email != u.email AND v.expires_at > ?
+	ORDER BY v.id DESC
+	LIMIT 1`
+
+	var email string
+	err := db.QueryRow(query, userID, time.Now().UTC()).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get pending email: %w", err)
+	}
+
+	return email, nil
+}
+
+// IsEmailInUse reports whether an address belongs to a user other than
+// exceptUserID. Addresses that differ only in case are the same.
+func (db *DB) IsEmailInUse(email string, exceptUserID int) (bool, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM users WHERE email = ? COLLATE NOCASE AND id != ?"
+	if err := db.QueryRow(query, email, exceptUserID).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check email uniqueness: %w", err)
+	}
+
+	return count > 0, nil
+}
@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LogMailer writes emails to the server log instead of sending them. Meant
+// for development, where following a link from the log is enough.
+type LogMailer struct {
+	From string
+}
+
+// NewLogMailer creates a mailer that logs every message
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{From: from}
+}
+
+// Send logs the message
+func (m *LogMailer) Send(msg *Message) error {
+	log.Printf("Email from %s to %s: %s\n%s", m.From, msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileMailer writes every email to its own .eml file in a directory. The
+// files can be opened with any mail client or read by tests.
+type FileMailer struct {
+	From string
+	Dir  string
+}
+
+// NewFileMailer creates a mailer that writes messages to dir, creating it if
+// needed
+func NewFileMailer(from, dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	return &FileMailer{From: from, Dir: dir}, nil
+}
+
+// Send writes the message to a new file named after the time and recipient
+func (m *FileMailer) Send(msg *Message) error {
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	// format already checked the address
+	recipient, _ := mail.ParseAddress(msg.To)
+	name := fmt.Sprintf("%s_%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), filepath.Base(recipient.Address))
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+
+	log.Printf("Email to %s written to %s", msg.To, path)
+	return nil
+}
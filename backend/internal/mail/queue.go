@@ -0,0 +1,73 @@
+package mail
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// Number of emails that can wait to be sent
+const queueSize = 100
+
+// Queue sends emails in the background so requests don't wait for the mail
+// server, and don't take longer depending on whether an email was sent.
+type Queue struct {
+	mailer   Mailer
+	messages chan *Message
+	mu       sync.RWMutex
+	closed   bool
+	done     chan struct{}
+}
+
+// NewQueue starts a queue that delivers messages through mailer
+func NewQueue(mailer Mailer) *Queue {
+	q := &Queue{
+		mailer:   mailer,
+		messages: make(chan *Message, queueSize),
+		done:     make(chan struct{}),
+	}
+
+	go q.run()
+
+	return q
+}
+
+// Send queues a message. It fails if the queue is full or closed.
+func (q *Queue) Send(msg *Message) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return errors.New("mail queue is closed")
+	}
+
+	select {
+	case q.messages <- msg:
+		return nil
+	default:
+		return errors.New("mail queue is full")
+	}
+}
+
+// Close stops accepting messages and waits for the queued ones to be sent
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.messages)
+	}
+	q.mu.Unlock()
+
+	<-q.done
+}
+
+// run delivers queued messages until the queue is closed and empty
+func (q *Queue) run() {
+	defer close(q.done)
+
+	for msg := range q.messages {
+		if err := q.mailer.Send(msg); err != nil {
+			log.Printf("Failed to send email %q: %v", msg.Subject, err)
+		}
+	}
+}
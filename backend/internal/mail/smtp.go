@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer sends emails through an SMTP server. smtp.SendMail upgrades the
+// connection with STARTTLS when the server offers it, and refuses to send
+// credentials over an unencrypted connection to a remote server.
+type SMTPMailer struct {
+	From     string
+	Host     string
+	Port     int
+	Username string // Empty skips authentication
+	Password string
+}
+
+// NewSMTPMailer creates a mailer that sends through the given server
+func NewSMTPMailer(from, host string, port int, username, password string) *SMTPMailer {
+	return &SMTPMailer{
+		From:     from,
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+	}
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTPMailer) Send(msg *Message) error {
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	// format already checked both addresses
+	sender, _ := mail.ParseAddress(m.From)
+	recipient, _ := mail.ParseAddress(msg.To)
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	address := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	if err := smtp.SendMail(address, auth, sender.Address, []string{recipient.Address}, data); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", recipient.Address, err)
+	}
+
+	return nil
+}
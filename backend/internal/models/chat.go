@@ -5,10 +5,31 @@ import (
 )
 
 type Chat struct {
-	ID        int       `json:"id"`
-	Type      string    `json:"type"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	IsActive  bool      `json:"is_active"`
+	ID          int       `json:"id"`
+	Type        string    `json:"type"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	AvatarURL   *string   `json:"avatar_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	IsActive    bool      `json:"is_active"` // Inactive chats are archived and read-only
+}
+
+// ChatListItem is a chat as shown in a user's chat list
+type ChatListItem struct {
+	Chat
+	UnreadCount int             `json:"unread_count"`
+	LastMessage *MessagePreview `json:"last_message,omitempty"`
+	// The other participant of a direct chat
+	OtherUser *UserBasic `json:"other_user,omitempty"`
+}
+
+// MessagePreview is a short summary of the latest message of a chat
+type MessagePreview struct {
+	ID          int       `json:"id"`
+	SenderID    int       `json:"sender_id"`
+	Content     string    `json:"content"`
+	MessageType string    `json:"message_type"`
+	CreatedAt   time.Time `json:"created_at"`
+	Deleted     bool      `json:"deleted"`
 }
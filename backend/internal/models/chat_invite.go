@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+)
+
+// ChatInvite is a shareable token that lets users join a group chat
+type ChatInvite struct {
+	ID        int        `json:"id"`
+	ChatID    int        `json:"chat_id"`
+	Token     string     `json:"token"`
+	CreatedBy int        `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	MaxUses   *int       `json:"max_uses,omitempty"` // Unlimited if not set
+	UseCount  int        `json:"use_count"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	// Users who joined through the invite, only included for admins
+	Uses []ChatInviteUse `json:"uses,omitempty"`
+}
+
+// ChatInviteUse records a user joining a chat through an invite
+type ChatInviteUse struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	UsedAt   time.Time `json:"used_at"`
+}
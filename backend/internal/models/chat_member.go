@@ -11,9 +11,14 @@ type ChatMember struct {
 	Role       string     `json:"role"`
 	JoinedAt   time.Time  `json:"joined_at"`
 	LastReadAt *time.Time `json:"last_read_at,omitempty"`
+	// Every message up to this ID has been read by the member
+	LastReadMessageID *int `json:"last_read_message_id,omitempty"`
 
 	// Additional fields from the user table
-	Username          string  `json:"username"`
-	Status            string  `json:"status"`
-	ProfilePictureURL *string `json:"profile_picture_url,omitempty"`
+	Username          string     `json:"username"`
+	Status            string     `json:"status"`
+	ProfilePictureURL *string    `json:"profile_picture_url,omitempty"`
+	LastSeenAt        *time.Time `json:"last_seen_at,omitempty"`
+	LastSeen          string     `json:"last_seen,omitempty"`
+	HideLastSeen      bool       `json:"-"`
 }
@@ -12,5 +12,68 @@ type Message struct {
 	MediaFileID *int       `json:"media_file_id,omitempty"`
 	MediaFile   *MediaFile `json:"media_file,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
-	IsRead      bool       `json:"is_read"`
+	// For the requesting user's own messages IsRead means another member read
+	// them; for everyone else's it means the requesting user read them
+	IsRead   bool       `json:"is_read"`
+	ReadBy   int        `json:"read_by"` // Number of members other than the sender who read it
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// Deleted messages are kept as tombstones without content or media
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy *int       `json:"deleted_by,omitempty"`
+	// Replies carry a quote of the message they answer
+	ReplyToMessageID *int          `json:"reply_to_message_id,omitempty"`
+	ReplyTo          *MessageQuote `json:"reply_to,omitempty"`
+	ReplyCount       int           `json:"reply_count"`
+	// Reactions are aggregated per emoji for the requesting user
+	Reactions []ReactionSummary `json:"reactions,omitempty"`
+}
+
+// ReactionSummary is the number of users who reacted to a message with an emoji
+type ReactionSummary struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"` // Whether the requesting user is one of them
+}
+
+// MessageQuote is a short preview of a message that another message replies to
+type MessageQuote struct {
+	ID          int    `json:"id"`
+	SenderID    int    `json:"sender_id"`
+	Content     string `json:"content"`
+	MessageType string `json:"message_type"`
+	Deleted     bool   `json:"deleted"`
+}
+
+// MessageEdit is a previous revision of an edited message
+type MessageEdit struct {
+	ID        int       `json:"id"`
+	MessageID int       `json:"message_id"`
+	Content   string    `json:"content"`
+	EditedBy  int       `json:"edited_by"`
+	EditedAt  time.Time `json:"edited_at"` // When this revision was replaced
+}
+
+// MessageSearchResult is a message matching a search query
+type MessageSearchResult struct {
+	Message
+	// Snippet is an excerpt of the content with the matches wrapped in
+	// <mark> and </mark>. The rest of the text is not HTML-escaped.
+	Snippet string       `json:"snippet"`
+	Chat    *ChatContext `json:"chat,omitempty"`
+}
+
+// ChatContext identifies the chat a search result belongs to
+type ChatContext struct {
+	ID   int    `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"` // The other user's username for direct chats
+}
+
+// MessagePage is a page of chat messages, newest first
+type MessagePage struct {
+	Messages      []Message `json:"messages"`
+	HasMoreBefore bool      `json:"has_more_before"` // Older messages exist
+	HasMoreAfter  bool      `json:"has_more_after"`  // Newer messages exist
+	PrevCursor    *int      `json:"prev_cursor"`     // Oldest message ID, for before_id
+	NextCursor    *int      `json:"next_cursor"`     // Newest message ID, for after_id
 }
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a login on one device, kept alive by a refresh token
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"-"`
+	DeviceName string    `json:"device_name,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Whether this is the session the request was made from
+	Current bool `json:"current"`
+}
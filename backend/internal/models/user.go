@@ -14,14 +14,25 @@ type User struct {
 	CreatedAt         time.Time  `json:"created_at"`
 	LastLogin         *time.Time `json:"last_login,omitempty"`
 	Status            string     `json:"status"`
+	LastSeenAt        *time.Time `json:"last_seen_at,omitempty"`
+	HideLastSeen      bool       `json:"hide_last_seen"`
+	DisabledAt        *time.Time `json:"-"` // Disabled accounts can't log in
+	EmailVerifiedAt   *time.Time `json:"-"` // Nil until the user follows the emailed link
+	TOTPSecret        string     `json:"-"` // Set once the user starts enrolling in 2FA
+	TOTPEnabledAt     *time.Time `json:"-"` // Nil unless 2FA is on
 }
 
 // UserBasic represents basic user information for public endpoints
 type UserBasic struct {
-	ID                int     `json:"id"`
-	Username          string  `json:"username"`
-	Status            string  `json:"status"`
-	ProfilePictureURL *string `json:"profile_picture_url,omitempty"`
+	ID                int        `json:"id"`
+	Username          string     `json:"username"`
+	Status            string     `json:"status"`
+	ProfilePictureURL *string    `json:"profile_picture_url,omitempty"`
+	LastSeenAt        *time.Time `json:"last_seen_at,omitempty"`
+	// LastSeen is a coarse replacement for LastSeenAt ("recently",
+	// "within_week", ...) for users who hide their exact last-seen time
+	LastSeen     string `json:"last_seen,omitempty"`
+	HideLastSeen bool   `json:"-"`
 }
 type UserProfile struct {
 	ID        int        `json:"id"`
@@ -0,0 +1,425 @@
+package presence
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"OurChat/internal/db"
+	"OurChat/internal/models"
+	"OurChat/internal/realtime"
+)
+
+const (
+	// How often expired typing reports are swept
+	typingSweepInterval = time.Second
+
+	// How often idle users are demoted and last-seen times are stored
+	activitySweepInterval = 15 * time.Second
+)
+
+// Options configures the presence service
+type Options struct {
+	// How long a typing report stays valid without being refreshed
+	TypingTTL time.Duration
+	// Idle time after which an active user is shown as away
+	AwayAfter time.Duration
+	// Idle time after which a user without live connections is shown as offline.
+	// Users with a live connection never drop below away.
+	OfflineAfter time.Duration
+}
+
+// DefaultOptions returns the options used when nothing else is configured
+func DefaultOptions() Options {
+	return Options{
+		TypingTTL:    6 * time.Second,
+		AwayAfter:    5 * time.Minute,
+		OfflineAfter: 15 * time.Minute,
+	}
+}
+
+// Statuses a user can pick that override the activity-derived status
+var manualStatuses = map[string]bool{
+	"away": true,
+	"busy": true,
+}
+
+// Presence levels derived from activity
+const (
+	levelOnline  = "online"
+	levelAway    = "away"
+	levelOffline = "offline"
+)
+
+type typingKey struct {
+	ChatID int
+	UserID int
+}
+
+// activity is the in-memory activity record of a user
+type activity struct {
+	LastSeen  time.Time
+	Persisted time.Time // last LastSeen value written to the database
+	Level     string    // last level announced to contacts
+}
+
+// Service keeps in-memory presence state: how recently each user was active
+// and who is typing where. Only last-seen times are written to the database.
+type Service struct {
+	DB      *db.DB
+	Hub     *realtime.Hub
+	Options Options
+
+	mu       sync.Mutex
+	typing   map[typingKey]time.Time
+	activity map[int]*activity
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewService creates the presence service, subscribes it to the hub's
+// connection events and starts the background sweeper
+func NewService(db *db.DB, hub *realtime.Hub, options Options) *Service {
+	s := &Service{
+		DB:       db,
+		Hub:      hub,
+		Options:  options,
+		typing:   make(map[typingKey]time.Time),
+		activity: make(map[int]*activity),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	hub.SetPresenceListener(s)
+	go s.run()
+
+	return s
+}
+
+// Close stops the background sweeper and stores pending last-seen times
+func (s *Service) Close() {
+	close(s.stop)
+	<-s.done
+
+	s.sweepActivity()
+}
+
+// Touch records activity for the user, such as an API request or a new
+// connection. Contacts are notified right away if the user was idle.
+func (s *Service) Touch(userID int) {
+	now := time.Now()
+
+	s.mu.Lock()
+	a, ok := s.activity[userID]
+	if !ok {
+		a = &activity{Level: levelOffline}
+		s.activity[userID] = a
+	}
+	a.LastSeen = now
+	changed := a.Level != levelOnline
+	a.Level = levelOnline
+	s.mu.Unlock()
+
+	if changed {
+		s.publishPresence(userID, levelOnline)
+	}
+}
+
+// level derives the presence level from the user's activity record
+func (s *Service) level(userID int, a *activity, now time.Time) string {
+	idle := now.Sub(a.LastSeen)
+	switch {
+	case idle < s.Options.AwayAfter:
+		return levelOnline
+	case idle < s.Options.OfflineAfter || s.Hub.IsConnected(userID):
+		return levelAway
+	default:
+		return levelOffline
+	}
+}
+
+// EffectiveStatus derives the status shown to other users from their recent
+// activity. A stored "away" or "busy" is kept while the user is active.
+func (s *Service) EffectiveStatus(userID int, storedStatus string) string {
+	s.mu.Lock()
+	a, ok := s.activity[userID]
+	level := levelOffline
+	if ok {
+		level = s.level(userID, a, time.Now())
+	}
+	s.mu.Unlock()
+
+	if level == levelOnline && manualStatuses[storedStatus] {
+		return storedStatus
+	}
+	return level
+}
+
+// LastSeen returns the last activity recorded in memory for the user
+func (s *Service) LastSeen(userID int) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.activity[userID]
+	if !ok {
+		return time.Time{}, false
+	}
+	return a.LastSeen, true
+}
+
+// DecorateUser replaces the stored status and last-seen time of a user with
+// live presence. Users who hide their last-seen time only get a coarse hint.
+func (s *Service) DecorateUser(user *models.UserBasic) {
+	user.Status = s.EffectiveStatus(user.ID, user.Status)
+
+	if lastSeen, ok := s.LastSeen(user.ID); ok {
+		user.LastSeenAt = &lastSeen
+	}
+
+	if user.HideLastSeen && user.LastSeenAt != nil {
+		user.LastSeen = ApproximateLastSeen(*user.LastSeenAt, time.Now())
+		user.LastSeenAt = nil
+	}
+}
+
+// DecorateMember applies DecorateUser to the user fields of a chat member
+func (s *Service) DecorateMember(member *models.ChatMember) {
+	user := models.UserBasic{
+		ID:           member.UserID,
+		Status:       member.Status,
+		LastSeenAt:   member.LastSeenAt,
+		HideLastSeen: member.HideLastSeen,
+	}
+	s.DecorateUser(&user)
+
+	member.Status = user.Status
+	member.LastSeenAt = user.LastSeenAt
+	member.LastSeen = user.LastSeen
+}
+
+// ApproximateLastSeen turns an exact last-seen time into a coarse bucket
+func ApproximateLastSeen(lastSeen, now time.Time) string {
+	since := now.Sub(lastSeen)
+	switch {
+	case since < 3*24*time.Hour:
+		return "recently"
+	case since < 7*24*time.Hour:
+		return "within_week"
+	case since < 30*24*time.Hour:
+		return "within_month"
+	default:
+		return "long_ago"
+	}
+}
+
+// SetTyping records that the user started or stopped typing in a chat.
+// Members are only notified when the state actually changes.
+func (s *Service) SetTyping(chatID, userID int, typing bool) {
+	key := typingKey{ChatID: chatID, UserID: userID}
+
+	s.mu.Lock()
+	_, wasTyping := s.typing[key]
+	if typing {
+		s.typing[key] = time.Now().Add(s.Options.TypingTTL)
+	} else {
+		delete(s.typing, key)
+	}
+	s.mu.Unlock()
+
+	if wasTyping != typing {
+		s.publishTyping(chatID, userID, typing)
+	}
+}
+
+// TypingUsers returns the IDs of users currently typing in a chat
+func (s *Service) TypingUsers(chatID int) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	userIDs := make([]int, 0)
+	for key, expiresAt := range s.typing {
+		if key.ChatID == chatID && expiresAt.After(now) {
+			userIDs = append(userIDs, key.UserID)
+		}
+	}
+
+	return userIDs
+}
+
+// UserConnected is called by the hub when the user's first connection opens
+func (s *Service) UserConnected(userID int) {
+	s.Touch(userID)
+}
+
+// UserDisconnected is called by the hub when the user's last connection closes
+func (s *Service) UserDisconnected(userID int) {
+	// A user who went away can't still be typing
+	s.mu.Lock()
+	var stopped []typingKey
+	for key := range s.typing {
+		if key.UserID == userID {
+			stopped = append(stopped, key)
+			delete(s.typing, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, key := range stopped {
+		s.publishTyping(key.ChatID, key.UserID, false)
+	}
+
+	// Closing the last connection counts as activity, so the user only
+	// drops to away or offline once they've been gone long enough
+	s.Touch(userID)
+}
+
+// run sweeps expired typing reports and idle users until the service is closed
+func (s *Service) run() {
+	defer close(s.done)
+
+	typingTicker := time.NewTicker(typingSweepInterval)
+	defer typingTicker.Stop()
+
+	activityTicker := time.NewTicker(activitySweepInterval)
+	defer activityTicker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-typingTicker.C:
+			s.sweepTyping()
+		case <-activityTicker.C:
+			s.sweepActivity()
+		}
+	}
+}
+
+// sweepActivity demotes idle users, notifies their contacts and stores
+// last-seen times that changed since the previous sweep
+func (s *Service) sweepActivity() {
+	now := time.Now()
+
+	type update struct {
+		UserID   int
+		Level    string
+		LastSeen time.Time
+		Changed  bool
+		Persist  bool
+	}
+
+	s.mu.Lock()
+	var updates []update
+	for userID, a := range s.activity {
+		level := s.level(userID, a, now)
+		u := update{
+			UserID:   userID,
+			Level:    level,
+			LastSeen: a.LastSeen,
+			Changed:  level != a.Level,
+			Persist:  !a.LastSeen.Equal(a.Persisted),
+		}
+		a.Level = level
+		a.Persisted = a.LastSeen
+
+		// Offline users are only kept in the database
+		if level == levelOffline {
+			delete(s.activity, userID)
+		}
+
+		if u.Changed || u.Persist {
+			updates = append(updates, u)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, u := range updates {
+		if u.Persist {
+			if err := s.DB.UpdateLastSeen(u.UserID, u.LastSeen); err != nil {
+				log.Printf("Failed to store last seen for user %d: %v", u.UserID, err)
+			}
+		}
+		if u.Changed {
+			s.publishPresence(u.UserID, u.Level)
+		}
+	}
+}
+
+// sweepTyping drops typing reports that weren't refreshed in time
+func (s *Service) sweepTyping() {
+	now := time.Now()
+
+	s.mu.Lock()
+	var expired []typingKey
+	for key, expiresAt := range s.typing {
+		if !expiresAt.After(now) {
+			expired = append(expired, key)
+			delete(s.typing, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, key := range expired {
+		s.publishTyping(key.ChatID, key.UserID, false)
+	}
+}
+
+// publishTyping notifies the other members of a chat about a typing change
+func (s *Service) publishTyping(chatID, userID int, typing bool) {
+	members, err := s.DB.GetChatMembers(chatID)
+	if err != nil {
+		log.Printf("Failed to get members of chat %d for typing event: %v", chatID, err)
+		return
+	}
+
+	userIDs := make([]int, 0, len(members))
+	for _, member := range members {
+		if member.UserID != userID {
+			userIDs = append(userIDs, member.UserID)
+		}
+	}
+
+	s.Hub.SendToUsers(userIDs, &realtime.Event{
+		Type:   realtime.EventTyping,
+		ChatID: chatID,
+		Data: map[string]interface{}{
+			"user_id": userID,
+			"typing":  typing,
+		},
+	})
+}
+
+// publishPresence notifies everyone who shares a chat with the user. Users who
+// hide their last-seen time only announce coming online, since announcing the
+// moment they go idle would give away the time they were last active.
+func (s *Service) publishPresence(userID int, level string) {
+	user, err := s.DB.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user %d for presence event: %v", userID, err)
+		return
+	}
+
+	if level != levelOnline && user.HideLastSeen {
+		return
+	}
+
+	contactIDs, err := s.DB.GetContactIDs(userID)
+	if err != nil {
+		log.Printf("Failed to get contacts of user %d for presence event: %v", userID, err)
+		return
+	}
+
+	status := level
+	if level == levelOnline && manualStatuses[user.Status] {
+		status = user.Status
+	}
+
+	s.Hub.SendToUsers(contactIDs, &realtime.Event{
+		Type: realtime.EventPresence,
+		Data: map[string]interface{}{
+			"user_id": userID,
+			"status":  status,
+		},
+	})
+}
@@ -0,0 +1,73 @@
+// Package ratelimit limits how often an action can be taken per key, such as
+// per email address
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// keyWindow counts one key's actions in the current window
+type keyWindow struct {
+	count   int
+	resetAt time.Time
+}
+
+// Limiter allows up to Limit actions per key in every fixed window of time
+type Limiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]*keyWindow
+	nextSweep time.Time
+}
+
+// NewLimiter creates a limiter allowing limit actions per key every window
+func NewLimiter(limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		Limit:   limit,
+		Window:  window,
+		windows: make(map[string]*keyWindow),
+	}
+}
+
+// Allow counts an action for the key. If the limit is reached it returns
+// false and how long until the key can act again.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget finished windows now and then so the map doesn't grow forever
+	if !now.Before(l.nextSweep) {
+		for k, w := range l.windows {
+			if !now.Before(w.resetAt) {
+				delete(l.windows, k)
+			}
+		}
+		l.nextSweep = now.Add(l.Window)
+	}
+
+	w, ok := l.windows[key]
+	if !ok || !now.Before(w.resetAt) {
+		w = &keyWindow{resetAt: now.Add(l.Window)}
+		l.windows[key] = w
+	}
+
+	if w.count >= l.Limit {
+		return false, w.resetAt.Sub(now)
+	}
+
+	w.count++
+	return true, 0
+}
+
+// Reset forgets the actions counted for the key, for limits that only apply
+// to failed attempts
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	delete(l.windows, key)
+	l.mu.Unlock()
+}
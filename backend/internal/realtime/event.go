@@ -0,0 +1,41 @@
+package realtime
+
+// Event types pushed to connected clients
+const (
+	EventMessageNew     = "message.new"
+	EventMessageEdited  = "message.edited"
+	EventMessageDeleted = "message.deleted"
+	EventMessagesRead   = "messages.read"
+	EventReaction       = "message.reaction"
+	EventMembersChange  = "chat.members"
+	EventChatUpdated    = "chat.updated"
+	EventTyping         = "typing"
+	EventPresence       = "presence"
+	EventResync         = "resync"
+)
+
+// Event is a single realtime notification delivered to a user's connections
+type Event struct {
+	// ID is the ID of the message the event carries, if any. SSE clients
+	// use it to resume the stream after a reconnect.
+	ID     int         `json:"id,omitempty"`
+	Type   string      `json:"type"`
+	ChatID int         `json:"chat_id,omitempty"`
+	Data   interface{} `json:"data"`
+
+	// Seq orders state changes, set by the hub when it sends one
+	Seq int64 `json:"-"`
+}
+
+// isStateChange reports whether the event changes state a client keeps, other
+// than adding a message. Missed new messages can be read back from the
+// database, and typing and presence only matter while they happen, but
+// missing one of these leaves the client out of date.
+func (e *Event) isStateChange() bool {
+	switch e.Type {
+	case EventMessageNew, EventTyping, EventPresence:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,300 @@
+package realtime
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Size of the outgoing event buffer for every connection
+const clientSendBuffer = 64
+
+// Number of recent state changes remembered to tell whether a resuming SSE
+// client missed any
+const stateHistorySize = 4096
+
+// Client is a single realtime connection belonging to a user
+type Client struct {
+	UserID int
+	// Login session the connection was opened with, 0 for tokens issued
+	// before sessions existed
+	SessionID int
+	Send      chan *Event
+	// Sequence number of the last state change sent before the client was
+	// registered. It receives every later one.
+	StartSeq int64
+}
+
+// stateChange records who a state change was sent to
+type stateChange struct {
+	Seq     int64
+	UserIDs []int
+}
+
+// PresenceListener is notified when a user's first connection opens and
+// when their last connection closes
+type PresenceListener interface {
+	UserConnected(userID int)
+	UserDisconnected(userID int)
+}
+
+// Hub keeps track of all live connections, grouped by user
+type Hub struct {
+	mu       sync.RWMutex
+	clients  map[int]map[*Client]bool
+	listener PresenceListener
+	closed   bool
+
+	// Sequence number of the last state change and the most recent ones,
+	// oldest first
+	seq     int64
+	history []stateChange
+}
+
+// NewHub creates a new, empty hub
+func NewHub() *Hub {
+	return &Hub{
+		clients: make(map[int]map[*Client]bool),
+		// Start from the clock so sequence numbers from before a restart
+		// are always older than the hub's history
+		seq: time.Now().UnixNano(),
+	}
+}
+
+// SetPresenceListener registers the listener for connect and disconnect events
+func (h *Hub) SetPresenceListener(listener PresenceListener) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.listener = listener
+}
+
+// NewClient creates a client for the given user and session and registers it
+// with the hub. Once the hub is closed the client's send channel is closed
+// right away.
+func (h *Hub) NewClient(userID, sessionID int) *Client {
+	client := &Client{
+		UserID:    userID,
+		SessionID: sessionID,
+		Send:      make(chan *Event, clientSendBuffer),
+	}
+
+	h.mu.Lock()
+	client.StartSeq = h.seq
+	if h.closed {
+		h.mu.Unlock()
+		close(client.Send)
+		return client
+	}
+	firstConnection := len(h.clients[userID]) == 0
+	if firstConnection {
+		h.clients[userID] = make(map[*Client]bool)
+	}
+	h.clients[userID][client] = true
+	listener := h.listener
+	h.mu.Unlock()
+
+	log.Printf("Realtime client connected for user %d", userID)
+
+	// Notify outside the lock so the listener can publish events
+	if firstConnection && listener != nil {
+		listener.UserConnected(userID)
+	}
+
+	return client
+}
+
+// Unregister removes a client from the hub and closes its send channel.
+// It is safe to call more than once for the same client.
+func (h *Hub) Unregister(client *Client) {
+	h.mu.Lock()
+	userClients, ok := h.clients[client.UserID]
+	if !ok || !userClients[client] {
+		h.mu.Unlock()
+		return
+	}
+
+	delete(userClients, client)
+	lastConnection := len(userClients) == 0
+	if lastConnection {
+		delete(h.clients, client.UserID)
+	}
+	close(client.Send)
+	listener := h.listener
+	h.mu.Unlock()
+
+	log.Printf("Realtime client disconnected for user %d", client.UserID)
+
+	if lastConnection && listener != nil {
+		listener.UserDisconnected(client.UserID)
+	}
+}
+
+// DisconnectUser closes every live connection of a user
+func (h *Hub) DisconnectUser(userID int) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients[userID]))
+	for client := range h.clients[userID] {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.Unregister(client)
+	}
+}
+
+// DisconnectSession closes the live connections opened with a login session,
+// leaving the user's other devices connected
+func (h *Hub) DisconnectSession(sessionID int) {
+	if sessionID == 0 {
+		return
+	}
+
+	h.mu.RLock()
+	var clients []*Client
+	for _, userClients := range h.clients {
+		for client := range userClients {
+			if client.SessionID == sessionID {
+				clients = append(clients, client)
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.Unregister(client)
+	}
+}
+
+// Close disconnects every client and refuses new ones. WebSocket clients get a
+// close frame and SSE streams end, so their requests can finish.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	var clients []*Client
+	for _, userClients := range h.clients {
+		for client := range userClients {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		h.Unregister(client)
+	}
+
+	log.Printf("Closed %d realtime connections", len(clients))
+}
+
+// SendToUsers delivers an event to every connection of the given users.
+// Connections whose buffer is full are considered dead and get dropped.
+func (h *Hub) SendToUsers(userIDs []int, event *Event) {
+	if event.isStateChange() {
+		h.sendStateChange(userIDs, event)
+		return
+	}
+
+	h.mu.RLock()
+	slow := h.deliver(userIDs, event)
+	h.mu.RUnlock()
+
+	h.dropSlow(slow)
+}
+
+// sendStateChange numbers a state change, remembers who it went to and
+// delivers it. Numbering and delivering happen under the same lock, so a
+// client registered concurrently either gets the change or has a StartSeq
+// that covers it.
+func (h *Hub) sendStateChange(userIDs []int, event *Event) {
+	h.mu.Lock()
+	h.seq++
+
+	// The same event may be sent again to other users, so number a copy
+	numbered := *event
+	numbered.Seq = h.seq
+
+	h.history = append(h.history, stateChange{Seq: h.seq, UserIDs: slices.Clone(userIDs)})
+	if len(h.history) > stateHistorySize {
+		h.history = slices.Delete(h.history, 0, len(h.history)-stateHistorySize)
+	}
+
+	slow := h.deliver(userIDs, &numbered)
+	h.mu.Unlock()
+
+	h.dropSlow(slow)
+}
+
+// deliver queues an event on the connections of the given users and returns
+// the ones whose buffer is full. The caller must hold the lock.
+func (h *Hub) deliver(userIDs []int, event *Event) []*Client {
+	var slow []*Client
+	for _, userID := range userIDs {
+		for client := range h.clients[userID] {
+			select {
+			case client.Send <- event:
+			default:
+				slow = append(slow, client)
+			}
+		}
+	}
+
+	return slow
+}
+
+// MissedStateChanges reports whether a user may have been sent a state change
+// numbered after `after` and up to `upTo`. It errs on the side of yes when the
+// history doesn't reach back that far.
+func (h *Hub) MissedStateChanges(userID int, after, upTo int64) bool {
+	if after == upTo {
+		return false
+	}
+	if after > upTo {
+		return true
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(h.history) == 0 || h.history[0].Seq > after+1 {
+		return true
+	}
+
+	for _, change := range h.history {
+		if change.Seq > after && change.Seq <= upTo && slices.Contains(change.UserIDs, userID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dropSlow unregisters the clients that couldn't keep up
+func (h *Hub) dropSlow(slow []*Client) {
+	for _, client := range slow {
+		log.Printf("Dropping slow realtime client for user %d", client.UserID)
+		h.Unregister(client)
+	}
+}
+
+// ConnectedUsers returns the IDs of the users with at least one live connection
+func (h *Hub) ConnectedUsers() []int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	userIDs := make([]int, 0, len(h.clients))
+	for userID := range h.clients {
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs
+}
+
+// IsConnected reports whether the user has at least one live connection
+func (h *Hub) IsConnected(userID int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.clients[userID]) > 0
+}
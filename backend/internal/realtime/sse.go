@@ -0,0 +1,141 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interval between keep-alive comments on an idle SSE stream. Must stay below
+// the proxy read timeout (60s by default in nginx).
+const sseKeepAlivePeriod = 30 * time.Second
+
+// SSEStream writes hub events to a Server-Sent Events response
+type SSEStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+
+	// Position of the client in the event stream, sent as the SSE id: the
+	// newest message it has and the last state change it was sent
+	messageID int
+	seq       int64
+}
+
+// ParseSSEPosition parses a position sent back in Last-Event-ID, formatted
+// "<message ID>-<state change seq>". A bare message ID, as older servers
+// sent, has no seq and hasSeq is false.
+func ParseSSEPosition(id string) (messageID int, seq int64, hasSeq bool, err error) {
+	messagePart, seqPart, hasSeq := strings.Cut(id, "-")
+
+	messageID, err = strconv.Atoi(messagePart)
+	if err != nil || messageID < 0 {
+		return 0, 0, false, fmt.Errorf("invalid event ID %q", id)
+	}
+
+	if hasSeq {
+		seq, err = strconv.ParseInt(seqPart, 10, 64)
+		if err != nil || seq < 0 {
+			return 0, 0, false, fmt.Errorf("invalid event ID %q", id)
+		}
+	}
+
+	return messageID, seq, hasSeq, nil
+}
+
+// NewSSEStream prepares the response for streaming. It fails if the
+// ResponseWriter doesn't support flushing.
+func NewSSEStream(w http.ResponseWriter) (*SSEStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Tell nginx not to buffer the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &SSEStream{w: w, flusher: flusher}, nil
+}
+
+// SetPosition sets the position of the client in the event stream, sent
+// with the next event
+func (s *SSEStream) SetPosition(messageID int, seq int64) {
+	s.messageID = messageID
+	s.seq = seq
+}
+
+// WritePosition sends the client its position without an event. Browsers
+// still report it back in Last-Event-ID on reconnect.
+func (s *SSEStream) WritePosition() error {
+	if _, err := fmt.Fprintf(s.w, "id: %d-%d\n\n", s.messageID, s.seq); err != nil {
+		return err
+	}
+
+	s.flusher.Flush()
+	return nil
+}
+
+// WriteEvent writes a single event. Every event sets the SSE id field to the
+// client's position, so the browser reports it back in Last-Event-ID on
+// reconnect.
+func (s *SSEStream) WriteEvent(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	if event.ID > s.messageID {
+		s.messageID = event.ID
+	}
+	if event.Seq > s.seq {
+		s.seq = event.Seq
+	}
+
+	if _, err := fmt.Fprintf(s.w, "id: %d-%d\nevent: %s\ndata: %s\n\n", s.messageID, s.seq, event.Type, data); err != nil {
+		return err
+	}
+
+	s.flusher.Flush()
+	return nil
+}
+
+// Serve streams the client's events until the request is cancelled or the
+// hub drops the client. Live message events with an ID at or below skipUpTo
+// are skipped, since they were already replayed from the database.
+func (s *SSEStream) Serve(r *http.Request, client *Client, skipUpTo int) {
+	ticker := time.NewTicker(sseKeepAlivePeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-client.Send:
+			if !ok {
+				return
+			}
+			if event.ID > 0 && event.ID <= skipUpTo {
+				continue
+			}
+			if err := s.WriteEvent(event); err != nil {
+				log.Printf("SSE write error for user %d: %v", client.UserID, err)
+				return
+			}
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(s.w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			s.flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package realtime
+
+import (
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	writeWait = 10 * time.Second
+
+	// Time allowed to read the next pong message from the peer
+	pongWait = 60 * time.Second
+
+	// Send pings to peer with this period (must be less than pongWait)
+	pingPeriod = (pongWait * 9) / 10
+
+	// Maximum message size allowed from peer
+	maxMessageSize = 4096
+)
+
+// ServeWebSocket attaches an upgraded WebSocket connection to the hub and
+// blocks until the connection is closed
+func (h *Hub) ServeWebSocket(conn *websocket.Conn, userID, sessionID int) {
+	client := h.NewClient(userID, sessionID)
+
+	go h.writePump(conn, client)
+	h.readPump(conn, client)
+}
+
+// readPump keeps the read side of the connection alive so that pongs and
+// close frames are processed. Clients don't send anything we act on yet.
+func (h *Hub) readPump(conn *websocket.Conn, client *Client) {
+	defer func() {
+		h.Unregister(client)
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		return nil
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("WebSocket read error for user %d: %v", client.UserID, err)
+			}
+			return
+		}
+	}
+}
+
+// writePump forwards events from the hub to the connection and sends
+// periodic pings so dead peers are detected
+func (h *Hub) writePump(conn *websocket.Conn, client *Client) {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case event, ok := <-client.Send:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				// The hub closed the channel
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := conn.WriteJSON(event); err != nil {
+				log.Printf("WebSocket write error for user %d: %v", client.UserID, err)
+				return
+			}
+
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
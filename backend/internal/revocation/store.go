@@ -0,0 +1,163 @@
+// Package revocation keeps track of access tokens that were revoked before
+// they expired, so the auth middleware can reject them without a database
+// query per request.
+package revocation
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"OurChat/internal/db"
+)
+
+// How often expired entries are dropped
+const sweepInterval = 10 * time.Minute
+
+// Store holds the revoked tokens and sessions that could still be presented.
+// Revocations are written to the database first and loaded back on startup.
+type Store struct {
+	DB *db.DB
+	// Lifetime of access tokens. A revoked session's tokens are all expired
+	// once this much time has passed.
+	TokenLifetime time.Duration
+
+	mu       sync.RWMutex
+	tokens   map[string]time.Time // jti -> token expiry
+	sessions map[int]time.Time    // session ID -> expiry of its last token
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewStore loads the revocations that are still relevant and starts the
+// background sweeper
+func NewStore(database *db.DB, tokenLifetime time.Duration) (*Store, error) {
+	s := &Store{
+		DB:            database,
+		TokenLifetime: tokenLifetime,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	tokens, err := database.GetRevokedTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	revokedSessions, err := database.GetSessionsRevokedSince(time.Now().Add(-tokenLifetime))
+	if err != nil {
+		return nil, err
+	}
+
+	s.tokens = tokens
+	s.sessions = make(map[int]time.Time, len(revokedSessions))
+	for id, revokedAt := range revokedSessions {
+		s.sessions[id] = revokedAt.Add(tokenLifetime)
+	}
+
+	go s.run()
+
+	return s, nil
+}
+
+// Close stops the background sweeper
+func (s *Store) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+// RevokeToken stops a single access token from being accepted
+func (s *Store) RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	if err := s.DB.RevokeToken(jti, userID, expiresAt); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[jti] = expiresAt
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RevokeSession ends one of a user's sessions. Its refresh token stops
+// working and so do the access tokens already issued to it.
+func (s *Store) RevokeSession(sessionID, userID int) error {
+	if err := s.DB.RevokeSession(sessionID, userID); err != nil {
+		return err
+	}
+
+	s.SessionRevoked(sessionID)
+	return nil
+}
+
+// SessionRevoked records a session that was just revoked in the database by
+// other means, so its access tokens stop being accepted too
+func (s *Store) SessionRevoked(sessionID int) {
+	s.mu.Lock()
+	s.sessions[sessionID] = time.Now().Add(s.TokenLifetime)
+	s.mu.Unlock()
+}
+
+// IsRevoked reports whether a token with the given jti, issued to the given
+// session, was revoked. Empty or zero values are skipped, since tokens issued
+// before sessions and token IDs existed carry neither.
+func (s *Store) IsRevoked(jti string, sessionID int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[jti]; ok && jti != "" {
+		return true
+	}
+	if _, ok := s.sessions[sessionID]; ok && sessionID != 0 {
+		return true
+	}
+
+	return false
+}
+
+// run sweeps expired entries until the store is closed
+func (s *Store) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.sweep(); err != nil {
+				log.Printf("Failed to sweep revoked tokens: %v", err)
+			}
+		}
+	}
+}
+
+// sweep drops revocations of tokens that have expired anyway
+func (s *Store) sweep() error {
+	now := time.Now()
+
+	s.mu.Lock()
+	for jti, expiresAt := range s.tokens {
+		if !expiresAt.After(now) {
+			delete(s.tokens, jti)
+		}
+	}
+	for id, expiresAt := range s.sessions {
+		if !expiresAt.After(now) {
+			delete(s.sessions, id)
+		}
+	}
+	s.mu.Unlock()
+
+	deleted, err := s.DB.DeleteExpiredRevocations()
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d expired token revocations", deleted)
+	}
+
+	return nil
+}
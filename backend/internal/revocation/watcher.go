@@ -0,0 +1,77 @@
+package revocation
+
+import (
+	"log"
+	"time"
+
+	"OurChat/internal/db"
+	"OurChat/internal/realtime"
+)
+
+// How often live realtime connections are checked for disabled users
+const watchInterval = 30 * time.Second
+
+// Watcher closes the realtime connections of users who were disabled. Accounts
+// are disabled from the command line, in another process, so the hub can't be
+// told directly; the database is checked periodically instead.
+type Watcher struct {
+	DB  *db.DB
+	Hub *realtime.Hub
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewWatcher creates a watcher and starts checking in the background
+func NewWatcher(database *db.DB, hub *realtime.Hub) *Watcher {
+	w := &Watcher{
+		DB:   database,
+		Hub:  hub,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go w.run()
+
+	return w
+}
+
+// Close stops the background checks
+func (w *Watcher) Close() {
+	close(w.stop)
+	<-w.done
+}
+
+// run checks the connected users until the watcher is closed
+func (w *Watcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.check(); err != nil {
+				log.Printf("Failed to check realtime connections: %v", err)
+			}
+		}
+	}
+}
+
+// check disconnects every connected user who is disabled
+func (w *Watcher) check() error {
+	disabled, err := w.DB.GetDisabledUserIDs(w.Hub.ConnectedUsers())
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range disabled {
+		log.Printf("Closing realtime connections of disabled user %d", userID)
+		w.Hub.DisconnectUser(userID)
+	}
+
+	return nil
+}
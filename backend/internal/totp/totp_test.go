@@ -0,0 +1,70 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// Secret of the RFC 6238 test vectors ("12345678901234567890"), base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	key, err := encoding.DecodeString(rfcSecret)
+	if err != nil {
+		t.Fatalf("failed to decode secret: %v", err)
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		if got := generate(key, step); got != tt.code {
+			t.Errorf("code at %d = %q, want %q", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		at       time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, "050471", now, step, true},
+		{"surrounding spaces", rfcSecret, " 050471 ", now, step, true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050471", now, step, true},
+		{"previous step within skew", rfcSecret, "050471", now.Add(Period), step, true},
+		{"next step within skew", rfcSecret, "050471", now.Add(-Period), step, true},
+		{"outside skew", rfcSecret, "050471", now.Add(2 * Period), 0, false},
+		{"wrong code", rfcSecret, "123456", now, 0, false},
+		{"too short", rfcSecret, "05047", now, 0, false},
+		{"too long", rfcSecret, "0504711", now, 0, false},
+		{"invalid secret", "not base32!", "050471", now, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := Validate(tt.secret, tt.code, tt.at)
+			if gotOK != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate() = (%d, %v), want (%d, %v)", gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+//go:build embed_frontend
+
+package web
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// The frontend build, copied from frontend/build before compiling
+//
+//go:embed all:dist
+var dist embed.FS
+
+// Embedded returns the frontend build compiled into the binary
+func Embedded() fs.FS {
+	files, err := fs.Sub(dist, "dist")
+	if err != nil {
+		panic(err)
+	}
+	return files
+}
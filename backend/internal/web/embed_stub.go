@@ -0,0 +1,11 @@
+//go:build !embed_frontend
+
+package web
+
+import "io/fs"
+
+// Embedded returns nil, since this binary was built without the
+// embed_frontend tag
+func Embedded() fs.FS {
+	return nil
+}
@@ -0,0 +1,81 @@
+// Package web serves the static build of the frontend
+package web
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// FallbackPage is served for paths without a file, so the single-page app can
+// route them in the browser
+const FallbackPage = "200.html"
+
+// Handler serves a static SvelteKit build like nginx's
+// try_files $uri $uri.html $uri/ /200.html
+type Handler struct {
+	Files fs.FS
+}
+
+// NewHandler creates a handler serving the files of a frontend build
+func NewHandler(files fs.FS) *Handler {
+	return &Handler{
+		Files: files,
+	}
+}
+
+// HasFallback checks whether the build contains the fallback page
+func HasFallback(files fs.FS) bool {
+	_, err := fs.Stat(files, FallbackPage)
+	return err == nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+
+	candidates := []string{name + ".html", path.Join(name, "index.html"), FallbackPage}
+	if name != "" {
+		candidates = append([]string{name}, candidates...)
+	}
+
+	for _, candidate := range candidates {
+		if h.serveFile(w, r, candidate) {
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+// serveFile serves a regular file from the build, returning false if there is
+// none with that name
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, name string) bool {
+	file, err := h.Files.Open(name)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		return false
+	}
+
+	// Build assets have content hashes in their names; pages must be
+	// revalidated to pick up new builds
+	if strings.HasPrefix(name, "_app/immutable/") {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else if strings.HasSuffix(name, ".html") {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	http.ServeContent(w, r, name, info.ModTime(), content)
+	return true
+}
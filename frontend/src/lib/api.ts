@@ -0,0 +1,80 @@
+// Access tokens expire after a few minutes. Requests go through apiFetch,
+// which exchanges the refresh token for new tokens when the server answers
+// 401 and retries the request once.
+
+const TOKEN_KEY = 'jwt_token';
+const REFRESH_TOKEN_KEY = 'refresh_token';
+
+// Refresh in progress, shared by the requests that fail at the same time.
+// A refresh token works only once, so refreshing twice would log the user out.
+let refreshing: Promise<boolean> | null = null;
+
+// storeTokens saves the tokens returned by login and refresh
+export function storeTokens(data: { token: string; refresh_token?: string }) {
+	localStorage.setItem(TOKEN_KEY, data.token);
+	if (data.refresh_token) {
+		localStorage.setItem(REFRESH_TOKEN_KEY, data.refresh_token);
+	}
+}
+
+// clearTokens forgets the tokens of the current session
+export function clearTokens() {
+	localStorage.removeItem(TOKEN_KEY);
+	localStorage.removeItem(REFRESH_TOKEN_KEY);
+}
+
+// apiFetch is fetch with the access token attached
+export async function apiFetch(input: RequestInfo | URL, init: RequestInit = {}) {
+	const response = await fetch(input, withToken(init));
+	if (response.status !== 401 || !(await refreshTokens())) {
+		return response;
+	}
+
+	return fetch(input, withToken(init));
+}
+
+function withToken(init: RequestInit): RequestInit {
+	const headers = new Headers(init.headers);
+	headers.set('Authorization', `Bearer ${localStorage.getItem(TOKEN_KEY)}`);
+	return { ...init, headers };
+}
+
+// refreshTokens gets new tokens with the refresh token and reports whether it
+// worked. The tokens are cleared if the refresh token was rejected.
+function refreshTokens(): Promise<boolean> {
+	if (!refreshing) {
+		refreshing = exchangeRefreshToken().finally(() => {
+			refreshing = null;
+		});
+	}
+	return refreshing;
+}
+
+async function exchangeRefreshToken() {
+	const refreshToken = localStorage.getItem(REFRESH_TOKEN_KEY);
+	if (!refreshToken) {
+		return false;
+	}
+
+	try {
+		const response = await fetch('/api/refresh', {
+			method: 'POST',
+			headers: { 'Content-Type': 'application/json' },
+			body: JSON.stringify({ refresh_token: refreshToken })
+		});
+
+		if (!response.ok) {
+			// Network errors keep the tokens, but a rejected token is useless
+			if (response.status === 401) {
+				clearTokens();
+			}
+			return false;
+		}
+
+		storeTokens(await response.json());
+		return true;
+	} catch (error) {
+		console.error('Token refresh failed:', error);
+		return false;
+	}
+}